@@ -0,0 +1,23 @@
+package main
+
+/*
+#cgo CFLAGS: -x objective-c
+#cgo LDFLAGS: -framework Cocoa
+#import <Cocoa/Cocoa.h>
+int
+StartApp(void) {
+	[NSAutoreleasePool new];
+	[NSApplication sharedApplication];
+	[NSApp setActivationPolicy:NSApplicationActivationPolicyProhibited];
+	[NSApp run];
+	return 0;
+}
+*/
+import "C"
+
+// startApp runs the NSApplication run loop, which 1Password needs to see a
+// running application. It never returns; the caller's goroutine exits the
+// process once it is done.
+func startApp() {
+	C.StartApp()
+}
@@ -0,0 +1,10 @@
+//go:build !darwin
+// +build !darwin
+
+package main
+
+// startApp blocks forever. There is no native run loop outside of macOS, so
+// the caller's goroutine is responsible for exiting the process.
+func startApp() {
+	select {}
+}
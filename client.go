@@ -1,21 +1,30 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/user"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/brycekahle/sudolikeaboss/onepass"
-	"github.com/kelseyhightower/envconfig"
-	log "github.com/sirupsen/logrus"
+	"github.com/brycekahle/sudolikeaboss/transport"
 )
 
 type Configuration struct {
 	TimeoutSecs    int    `split_words:"true" default:"30"`
 	DefaultHost    string `split_words:"true" default:"sudolikeaboss://local"`
 	StateDirectory string `split_words:"true"`
+	UnsafeLogging  bool   `split_words:"true"`
+	SecretStorage  string `split_words:"true" default:"plaintext"`
+	StateStore     string `split_words:"true" default:"file"`
+	Profile        string `flag:"profile"`
+	RulesFile      string `split_words:"true"`
+	Transport      string `default:"websocket"`
+	NativeHelper   string `split_words:"true"`
 
 	Websocket struct {
 		URI      string `default:"ws://127.0.0.1:6263/4"`
@@ -24,46 +33,82 @@ type Configuration struct {
 	}
 }
 
+// LoadConfiguration returns the effective configuration, see
+// loadConfiguration.
 func LoadConfiguration() *Configuration {
-	conf := Configuration{}
-	err := envconfig.Process("SUDOLIKEABOSS", &conf)
+	loaded, err := loadConfiguration(configFlags, configFileFlag)
 	if err != nil {
-		log.Fatal(err)
+		fail(err)
 	}
 
-	if conf.StateDirectory == "" {
-		usr, err := user.Current()
-		if err != nil {
-			log.Fatal(err)
-		}
-		conf.StateDirectory = path.Join(usr.HomeDir, ".sudolikeaboss")
+	return loaded.Configuration
+}
+
+// fetchItem asks an authenticated client for the item to read the password
+// from.
+type fetchItem func(ctx context.Context, client *onepass.OnePasswordClient) (*onepass.Response, error)
+
+// showPopup lets the user pick the item in the 1Password popup.
+func showPopup(ctx context.Context, client *onepass.OnePasswordClient) (*onepass.Response, error) {
+	return client.SendShowPopupCommand(ctx)
+}
+
+// getItem looks up the item by uuid or title without any user interaction.
+func getItem(uuid string, title string) fetchItem {
+	return func(ctx context.Context, client *onepass.OnePasswordClient) (*onepass.Response, error) {
+		return client.SendGetItemCommand(ctx, uuid, title)
 	}
+}
 
-	return &conf
+// retrieveOptions controls what is printed from the retrieved item and
+// where it goes.
+type retrieveOptions struct {
+	Field  string
+	Output string
+	// Sink delivers the output, stdout if nil.
+	Sink sink
 }
 
-func retrievePasswordFromOnepassword(configuration *onepass.Configuration, done chan bool) {
+func (options retrieveOptions) sink() sink {
+	if options.Sink == nil {
+		return &stdoutSink{W: os.Stdout}
+	}
+	return options.Sink
+}
+
+func retrievePasswordFromOnepassword(ctx context.Context, configuration *onepass.Configuration, fetch fetchItem, options retrieveOptions, done chan bool) {
 	// Load configuration from a file
 	client, err := onepass.NewClientWithConfig(configuration)
 	if err != nil {
-		os.Exit(1)
+		fail(err)
+	}
+	defer client.Close()
+
+	_, err = client.Authenticate(ctx, false)
+	if err != nil {
+		fail(err)
+	}
+
+	response, err := fetch(ctx, client)
+	if err != nil {
+		fail(err)
 	}
 
-	_, err = client.Authenticate(false)
+	item, err := response.GetItem()
 	if err != nil {
-		os.Exit(1)
+		fail(err)
 	}
 
-	response, err := client.SendShowPopupCommand()
+	var output bytes.Buffer
+	err = writeItem(&output, options.Output, item, options.Field)
 	if err != nil {
-		os.Exit(1)
+		fail(err)
 	}
 
-	password, err := response.GetPassword()
+	err = options.sink().Deliver(output.String())
 	if err != nil {
-		os.Exit(1)
+		fail(err)
 	}
-	fmt.Println(password)
 
 	done <- true
 }
@@ -72,12 +117,19 @@ func registerWithOnepassword(configuration *onepass.Configuration, done chan boo
 	// Load configuration from a file
 	client, err := onepass.NewClientWithConfig(configuration)
 	if err != nil {
-		os.Exit(1)
+		fail(err)
 	}
+	defer client.Close()
 
-	_, err = client.Authenticate(true)
+	// Registering waits for the user to accept the code, so it isn't bounded
+	_, err = client.Authenticate(context.Background(), true)
+	if err == onepass.ErrAlreadyRegistered {
+		fmt.Println("sudolikeaboss is already registered.")
+		done <- true
+		return
+	}
 	if err != nil {
-		os.Exit(1)
+		fail(err)
 	}
 
 	fmt.Println("")
@@ -86,42 +138,136 @@ func registerWithOnepassword(configuration *onepass.Configuration, done chan boo
 	done <- true
 }
 
-// Run the main sudolikeaboss entry point
-func runSudolikeaboss() {
-	done := make(chan bool)
+// onepassConfiguration converts the CLI configuration into the one the
+// onepass client expects.
+// onepassConfiguration is the helperConfiguration with the popup URL picked
+// by the rules matching the terminal.
+func (conf *Configuration) onepassConfiguration() (onepass.Configuration, error) {
+	oc, err := conf.helperConfiguration()
+	if err != nil {
+		return onepass.Configuration{}, err
+	}
+
+	oc.DefaultHost, err = conf.popupURL(oc.DefaultHost, onepass.RunCommand)
+	if err != nil {
+		return onepass.Configuration{}, err
+	}
+
+	return oc, nil
+}
+
+// helperConfiguration says how to reach the helper and where the pairing is
+// kept, without inspecting the terminal.
+func (conf *Configuration) helperConfiguration() (onepass.Configuration, error) {
+	secretStore, err := onepass.NewSecretStore(conf.SecretStorage, passphraseReader())
+	if err != nil {
+		return onepass.Configuration{}, err
+	}
+
+	var stateStore onepass.StateStore
+	switch conf.StateStore {
+	case "file":
+		stateStore = onepass.NewFileStateStore(conf.StateDirectory)
+	case "env":
+		stateStore = onepass.NewEnvStateStore("")
+	default:
+		return onepass.Configuration{}, fmt.Errorf("unknown state store %q, expected file or env", conf.StateStore)
+	}
 
-	conf := LoadConfiguration()
 	oc := onepass.Configuration{
 		WebsocketURI:      conf.Websocket.URI,
 		WebsocketOrigin:   conf.Websocket.Origin,
 		WebsocketProtocol: conf.Websocket.Protocol,
 		StateDirectory:    conf.StateDirectory,
 		DefaultHost:       conf.DefaultHost,
+		UnsafeLogging:     conf.UnsafeLogging,
+		SecretStore:       secretStore,
+		StateStore:        stateStore,
 	}
-	go retrievePasswordFromOnepassword(&oc, done)
+
+	if conf.Profile != "" {
+		if conf.StateStore != "file" {
+			return onepass.Configuration{}, fmt.Errorf("profiles need the file state store, not %q", conf.StateStore)
+		}
+
+		profiles, err := onepass.LoadProfiles(conf.profilesPath())
+		if err != nil {
+			return onepass.Configuration{}, err
+		}
+
+		// An unknown profile starts out with the regular settings and is
+		// created once it is registered.
+		if profile, ok := profiles.Profiles[conf.Profile]; ok {
+			profile.Apply(&oc)
+		}
+		oc.StateStore = onepass.NewProfileStateStore(conf.StateDirectory, conf.Profile)
+	}
+
+	switch conf.Transport {
+	case "", "websocket":
+	case "native":
+		command := strings.Fields(conf.NativeHelper)
+		if len(command) == 0 {
+			return onepass.Configuration{}, errors.New("the native transport needs the command of the native messaging helper in nativeHelper")
+		}
+		oc.Dialer = transport.DialNativeMessaging(command[0], command[1:]...)
+	default:
+		return onepass.Configuration{}, fmt.Errorf("unknown transport %q, expected websocket or native", conf.Transport)
+	}
+
+	return oc, nil
+}
+
+// timeoutContext bounds talking to 1Password by TimeoutSecs.
+func (conf *Configuration) timeoutContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(conf.TimeoutSecs)*time.Second)
+}
+
+func (conf *Configuration) profilesPath() string {
+	return path.Join(conf.StateDirectory, onepass.ProfilesFileName)
+}
+
+// Run the main sudolikeaboss entry point
+func runSudolikeaboss(fetch fetchItem, options retrieveOptions) {
+	// done is buffered so a late reply doesn't block the worker while we fail
+	done := make(chan bool, 1)
+
+	conf := LoadConfiguration()
+	oc, err := conf.onepassConfiguration()
+	if err != nil {
+		fail(err)
+	}
+	ctx, cancel := conf.timeoutContext()
+	defer cancel()
+
+	go retrievePasswordFromOnepassword(ctx, &oc, fetch, options, done)
 
 	// Timeout if necessary
 	select {
 	case <-done:
 		// Do nothing no need
-	case <-time.After(time.Duration(conf.TimeoutSecs) * time.Second):
-		close(done)
-		os.Exit(1)
+	case <-ctx.Done():
+		fail(onepass.ErrTimeout)
+	}
+
+	// Clearing the clipboard isn't bound by the timeout, we exit once it's done
+	if sink, ok := options.sink().(waitingSink); ok {
+		err = sink.Wait()
+		if err != nil {
+			fail(err)
+		}
 	}
 	// Close the app neatly
 	os.Exit(0)
 }
 
 func runSudolikeabossRegistration() {
-	done := make(chan bool)
+	done := make(chan bool, 1)
 
 	conf := LoadConfiguration()
-	oc := onepass.Configuration{
-		WebsocketURI:      conf.Websocket.URI,
-		WebsocketOrigin:   conf.Websocket.Origin,
-		WebsocketProtocol: conf.Websocket.Protocol,
-		StateDirectory:    conf.StateDirectory,
-		DefaultHost:       conf.DefaultHost,
+	oc, err := conf.onepassConfiguration()
+	if err != nil {
+		fail(err)
 	}
 
 	go registerWithOnepassword(&oc, done)
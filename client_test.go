@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+	"github.com/brycekahle/sudolikeaboss/onepass/fakehelper"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// captureStdout runs f with os.Stdout redirected and returns what it wrote.
+func captureStdout(f func()) string {
+	r, w, err := os.Pipe()
+	Expect(err).To(BeNil())
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	f()
+
+	Expect(w.Close()).To(Succeed())
+	out, err := ioutil.ReadAll(r)
+	Expect(err).To(BeNil())
+	return string(out)
+}
+
+// testHelper is a running fake helper with a state directory of its own.
+type testHelper struct {
+	*fakehelper.Helper
+	StateDir string
+}
+
+// startHelper starts a fake helper serving items and creates an empty state
+// directory for it.
+func startHelper(items ...*fakehelper.Item) *testHelper {
+	helper := fakehelper.NewHelper(fakehelper.NewVault(items...))
+	Expect(helper.Start()).To(Succeed())
+
+	stateDir, err := ioutil.TempDir("", "sudolikeaboss")
+	Expect(err).To(BeNil())
+
+	return &testHelper{Helper: helper, StateDir: stateDir}
+}
+
+// startRegisteredHelper is startHelper with sudolikeaboss registered.
+func startRegisteredHelper(items ...*fakehelper.Item) *testHelper {
+	helper := startHelper(items...)
+	helper.register()
+	return helper
+}
+
+// Config connects to the helper and keeps the pairing in its state directory.
+func (helper *testHelper) Config() *onepass.Configuration {
+	return helper.Configuration(helper.StateDir)
+}
+
+func (helper *testHelper) register() *onepass.StateFileConfig {
+	done := make(chan bool, 1)
+	captureStdout(func() { registerWithOnepassword(helper.Config(), done) })
+	Expect(<-done).To(BeTrue())
+
+	state, err := onepass.NewFileStateStore(helper.StateDir).Load()
+	Expect(err).To(BeNil())
+	return state
+}
+
+// Close stops the helper and removes its state directory.
+func (helper *testHelper) Close() {
+	Expect(helper.Helper.Close()).To(Succeed())
+	Expect(os.RemoveAll(helper.StateDir)).To(Succeed())
+}
+
+var _ = Describe("Client", func() {
+	var (
+		helper   *testHelper
+		stateDir string
+		conf     *onepass.Configuration
+	)
+
+	BeforeEach(func() {
+		helper = startHelper(&fakehelper.Item{
+			UUID:     "uuid",
+			Title:    "local",
+			URL:      "sudolikeaboss://local",
+			Username: "username",
+			Password: "password",
+		})
+		stateDir = helper.StateDir
+		conf = helper.Config()
+	})
+
+	AfterEach(func() {
+		helper.Close()
+	})
+
+	It("should load the configuration from the environment", func() {
+		os.Setenv("SUDOLIKEABOSS_WEBSOCKET_URI", helper.URL())
+		os.Setenv("SUDOLIKEABOSS_STATE_DIRECTORY", stateDir)
+		defer os.Unsetenv("SUDOLIKEABOSS_WEBSOCKET_URI")
+		defer os.Unsetenv("SUDOLIKEABOSS_STATE_DIRECTORY")
+
+		loaded := LoadConfiguration()
+		Expect(loaded.Websocket.URI).To(Equal(helper.URL()))
+		Expect(loaded.StateDirectory).To(Equal(stateDir))
+		Expect(loaded.DefaultHost).To(Equal("sudolikeaboss://local"))
+	})
+
+	It("should register and then print the password", func() {
+		done := make(chan bool, 1)
+
+		out := captureStdout(func() {
+			registerWithOnepassword(conf, done)
+		})
+		Expect(out).To(ContainSubstring("Congrats sudolikeaboss is registered!"))
+		Expect(<-done).To(BeTrue())
+
+		out = captureStdout(func() {
+			retrievePasswordFromOnepassword(context.Background(), conf, showPopup, retrieveOptions{Field: "password", Output: outputDefault}, done)
+		})
+		Expect(out).To(Equal("password\n"))
+		Expect(<-done).To(BeTrue())
+	})
+
+	Context("when registered", func() {
+		BeforeEach(func() {
+			helper.register()
+		})
+
+		It("should get an item by uuid", func() {
+			done := make(chan bool, 1)
+			out := captureStdout(func() {
+				retrievePasswordFromOnepassword(context.Background(), conf, getItem("uuid", ""), retrieveOptions{Field: "password", Output: outputDefault}, done)
+			})
+			Expect(out).To(Equal("password\n"))
+		})
+
+		It("should get an item by title", func() {
+			done := make(chan bool, 1)
+			out := captureStdout(func() {
+				retrievePasswordFromOnepassword(context.Background(), conf, getItem("", "local"), retrieveOptions{Field: "password", Output: outputDefault}, done)
+			})
+			Expect(out).To(Equal("password\n"))
+		})
+
+		It("should print the requested field", func() {
+			done := make(chan bool, 1)
+			out := captureStdout(func() {
+				retrievePasswordFromOnepassword(context.Background(), conf, showPopup, retrieveOptions{Field: "username", Output: outputDefault}, done)
+			})
+			Expect(out).To(Equal("username\n"))
+		})
+
+		It("should print the raw password without a newline", func() {
+			done := make(chan bool, 1)
+			out := captureStdout(func() {
+				retrievePasswordFromOnepassword(context.Background(), conf, showPopup, retrieveOptions{Field: "password", Output: outputRaw}, done)
+			})
+			Expect(out).To(Equal("password"))
+		})
+	})
+})
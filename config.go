@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v2"
+)
+
+// configFileNames are looked for, in order, in the XDG config directory and
+// then in the state directory.
+var configFileNames = []string{"config.toml", "config.json", "config.yaml", "config.yml"}
+
+// setting is a field of Configuration that can be set in the config file, the
+// environment and, for those tagged with flag, with a global flag.
+type setting struct {
+	Key  string
+	Env  string
+	Flag string
+	// index locates the field in Configuration, see reflect.Value.FieldByIndex.
+	index []int
+}
+
+// field returns a pointer to the value of s in conf.
+func (s setting) field(conf *Configuration) interface{} {
+	return reflect.ValueOf(conf).Elem().FieldByIndex(s.index).Addr().Interface()
+}
+
+// settings are derived from the fields of Configuration, so they can't get
+// out of step with what envconfig reads.
+var settings = structSettings(reflect.TypeOf(Configuration{}), nil, "", "SUDOLIKEABOSS")
+
+// structSettings lists the settings of the struct t, naming the environment
+// variables the way envconfig does and the keys in lowerCamelCase. Nested
+// structs become dotted keys, like websocket.uri.
+func structSettings(t reflect.Type, index []int, keyPrefix string, envPrefix string) []setting {
+	var result []setting
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+
+		env := field.Name
+		if field.Tag.Get("split_words") == "true" {
+			env = strings.Join(envconfigWords.FindAllString(field.Name, -1), "_")
+		}
+		env = strings.ToUpper(envPrefix + "_" + env)
+		key := keyPrefix + lowerCamel(field.Name)
+
+		if field.Type.Kind() == reflect.Struct {
+			result = append(result, structSettings(field.Type, fieldIndex, key+".", env)...)
+			continue
+		}
+
+		result = append(result, setting{Key: key, Env: env, Flag: field.Tag.Get("flag"), index: fieldIndex})
+	}
+	return result
+}
+
+// envconfigWords splits field names with split_words like envconfig does.
+var envconfigWords = regexp.MustCompile("([^A-Z]+|[A-Z][^A-Z]+|[A-Z]+)")
+
+// lowerCamel lowers the leading capitals of name, so URI becomes uri and
+// DefaultHost becomes defaultHost.
+func lowerCamel(name string) string {
+	upper := 0
+	for upper < len(name) && unicode.IsUpper(rune(name[upper])) {
+		upper++
+	}
+	if upper > 1 && upper < len(name) {
+		// the last capital starts the next word, as in URLPath
+		upper--
+	}
+	return strings.ToLower(name[:upper]) + name[upper:]
+}
+
+// configFlags are the settings given as global flags, by flag name, and the
+// config file given with --config. They are set before any command runs.
+var configFlags = map[string]string{}
+var configFileFlag string
+
+// loadedConfiguration is the effective configuration and where each of its
+// values came from.
+type loadedConfiguration struct {
+	Configuration *Configuration
+	File          string
+	Sources       map[string]string
+}
+
+// loadConfiguration layers global flags over the environment over the
+// config file over the defaults.
+func loadConfiguration(flags map[string]string, configFile string) (*loadedConfiguration, error) {
+	conf := Configuration{}
+	err := envconfig.Process("SUDOLIKEABOSS", &conf)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := &loadedConfiguration{Configuration: &conf, Sources: make(map[string]string)}
+	for _, s := range settings {
+		loaded.Sources[s.Key] = "default"
+		if _, ok := os.LookupEnv(s.Env); ok {
+			loaded.Sources[s.Key] = "env " + s.Env
+		}
+	}
+
+	if configFile == "" {
+		configFile, err = findFile(conf.StateDirectory, configFileNames)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if configFile != "" {
+		values, err := readConfigFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		loaded.File = configFile
+
+		for key, value := range values {
+			s := lookupSetting(key)
+			if s == nil {
+				return nil, fmt.Errorf("%s: unknown setting %q", configFile, key)
+			}
+			if _, ok := os.LookupEnv(s.Env); ok {
+				continue
+			}
+
+			err = setValue(s.field(&conf), value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %s", configFile, key, err)
+			}
+			loaded.Sources[s.Key] = "file " + configFile
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := flags[s.Flag]
+		if s.Flag == "" || !ok {
+			continue
+		}
+
+		err = setValue(s.field(&conf), value)
+		if err != nil {
+			return nil, fmt.Errorf("--%s: %s", s.Flag, err)
+		}
+		loaded.Sources[s.Key] = "flag --" + s.Flag
+	}
+
+	if conf.StateDirectory == "" {
+		conf.StateDirectory, err = defaultStateDirectory()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return loaded, nil
+}
+
+func defaultStateDirectory() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(usr.HomeDir, ".sudolikeaboss"), nil
+}
+
+func xdgConfigDirectory() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return path.Join(dir, "sudolikeaboss"), nil
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(usr.HomeDir, ".config", "sudolikeaboss"), nil
+}
+
+// findFile returns the first of names in the XDG config directory or the
+// state directory, or "" if there is none.
+func findFile(stateDirectory string, names []string) (string, error) {
+	xdgDirectory, err := xdgConfigDirectory()
+	if err != nil {
+		return "", err
+	}
+
+	if stateDirectory == "" {
+		stateDirectory, err = defaultStateDirectory()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, dir := range []string{xdgDirectory, stateDirectory} {
+		for _, name := range names {
+			candidate := path.Join(dir, name)
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// decodeFile parses a TOML, JSON or YAML file by its extension.
+func decodeFile(file string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]interface{})
+	switch path.Ext(file) {
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("%s: unknown file format, expected .toml, .json or .yaml", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return raw, nil
+}
+
+// readConfigFile parses the config file and flattens nested tables into
+// dotted keys.
+func readConfigFile(configFile string) (map[string]interface{}, error) {
+	raw, err := decodeFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	flattenConfig("", raw, values)
+	return values, nil
+}
+
+func flattenConfig(prefix string, raw map[string]interface{}, values map[string]interface{}) {
+	for key, value := range raw {
+		switch table := value.(type) {
+		case map[string]interface{}:
+			flattenConfig(prefix+key+".", table, values)
+		case map[interface{}]interface{}:
+			// YAML mappings may have keys of any type
+			converted := make(map[string]interface{}, len(table))
+			for k, v := range table {
+				converted[fmt.Sprint(k)] = v
+			}
+			flattenConfig(prefix+key+".", converted, values)
+		default:
+			values[prefix+key] = value
+		}
+	}
+}
+
+// lookupSetting finds the setting for a config file key. Keys match
+// regardless of case, dashes and underscores, so default_host works as well
+// as defaultHost.
+func lookupSetting(key string) *setting {
+	for i := range settings {
+		if normalizeKey(settings[i].Key) == normalizeKey(key) {
+			return &settings[i]
+		}
+	}
+	return nil
+}
+
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+}
+
+// setValue stores value, as decoded from a config file or given as a flag,
+// in the field pointed to by field.
+func setValue(field interface{}, value interface{}) error {
+	switch field := field.(type) {
+	case *string:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %v", value)
+		}
+		*field = s
+	case *int:
+		switch n := value.(type) {
+		case int:
+			*field = n
+		case int64:
+			*field = int(n)
+		case float64:
+			if n != float64(int(n)) {
+				return fmt.Errorf("expected a whole number, got %v", n)
+			}
+			*field = int(n)
+		case string:
+			i, err := strconv.Atoi(n)
+			if err != nil {
+				return err
+			}
+			*field = i
+		default:
+			return fmt.Errorf("expected a number, got %v", value)
+		}
+	case *bool:
+		switch b := value.(type) {
+		case bool:
+			*field = b
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return err
+			}
+			*field = parsed
+		default:
+			return fmt.Errorf("expected true or false, got %v", value)
+		}
+	default:
+		return fmt.Errorf("unsupported setting type %T", field)
+	}
+	return nil
+}
+
+// printConfiguration shows every setting with its effective value and where
+// it came from.
+func printConfiguration(w io.Writer, loaded *loadedConfiguration) error {
+	if loaded.File != "" {
+		fmt.Fprintf(w, "Config file: %s\n\n", loaded.File)
+	} else {
+		fmt.Fprintln(w, "Config file: none")
+		fmt.Fprintln(w, "")
+	}
+
+	keys := make([]string, 0, len(settings))
+	for _, s := range settings {
+		keys = append(keys, s.Key)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, key := range keys {
+		s := lookupSetting(key)
+		value := s.field(loaded.Configuration)
+		fmt.Fprintf(tw, "%s\t%v\t%s\n", key, displayValue(value), loaded.Sources[key])
+	}
+	return tw.Flush()
+}
+
+func displayValue(field interface{}) interface{} {
+	switch field := field.(type) {
+	case *string:
+		return strconv.Quote(*field)
+	case *int:
+		return *field
+	case *bool:
+		return *field
+	}
+	return field
+}
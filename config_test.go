@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Configuration", func() {
+	var (
+		xdgDir   string
+		stateDir string
+		err      error
+	)
+
+	BeforeEach(func() {
+		xdgDir, err = ioutil.TempDir("", "sudolikeaboss-xdg")
+		Expect(err).To(BeNil())
+		stateDir, err = ioutil.TempDir("", "sudolikeaboss")
+		Expect(err).To(BeNil())
+
+		os.Setenv("XDG_CONFIG_HOME", xdgDir)
+		os.Setenv("SUDOLIKEABOSS_STATE_DIRECTORY", stateDir)
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("XDG_CONFIG_HOME")
+		os.Unsetenv("SUDOLIKEABOSS_STATE_DIRECTORY")
+		Expect(os.RemoveAll(xdgDir)).To(Succeed())
+		Expect(os.RemoveAll(stateDir)).To(Succeed())
+	})
+
+	writeConfig := func(dir string, name string, content string) string {
+		Expect(os.MkdirAll(dir, 0700)).To(Succeed())
+		configFile := path.Join(dir, name)
+		Expect(ioutil.WriteFile(configFile, []byte(content), 0600)).To(Succeed())
+		return configFile
+	}
+
+	It("should name the settings after the configuration fields", func() {
+		Expect(lookupSetting("timeout_secs").Env).To(Equal("SUDOLIKEABOSS_TIMEOUT_SECS"))
+		Expect(lookupSetting("websocket.uri").Env).To(Equal("SUDOLIKEABOSS_WEBSOCKET_URI"))
+		Expect(lookupSetting("profile").Flag).To(Equal("profile"))
+		Expect(lookupSetting("transport").Env).To(Equal("SUDOLIKEABOSS_TRANSPORT"))
+
+		conf := &Configuration{}
+		*lookupSetting("nativeHelper").field(conf).(*string) = "helper"
+		Expect(conf.NativeHelper).To(Equal("helper"))
+	})
+
+	It("should use the defaults without a config file", func() {
+		loaded, err := loadConfiguration(nil, "")
+		Expect(err).To(BeNil())
+
+		Expect(loaded.File).To(BeEmpty())
+		Expect(loaded.Configuration.TimeoutSecs).To(Equal(30))
+		Expect(loaded.Sources["timeoutSecs"]).To(Equal("default"))
+		Expect(loaded.Sources["stateDirectory"]).To(Equal("env SUDOLIKEABOSS_STATE_DIRECTORY"))
+	})
+
+	It("should layer flags over the environment over the config file", func() {
+		configFile := writeConfig(path.Join(xdgDir, "sudolikeaboss"), "config.toml", `
+timeout_secs = 10
+defaultHost = "sudolikeaboss://file"
+profile = "file"
+
+[websocket]
+uri = "ws://file/4"
+`)
+		os.Setenv("SUDOLIKEABOSS_WEBSOCKET_URI", "ws://env/4")
+		os.Setenv("SUDOLIKEABOSS_PROFILE", "env")
+		defer os.Unsetenv("SUDOLIKEABOSS_WEBSOCKET_URI")
+		defer os.Unsetenv("SUDOLIKEABOSS_PROFILE")
+
+		loaded, err := loadConfiguration(map[string]string{"profile": "flag"}, "")
+		Expect(err).To(BeNil())
+
+		conf := loaded.Configuration
+		Expect(loaded.File).To(Equal(configFile))
+		Expect(conf.TimeoutSecs).To(Equal(10))
+		Expect(conf.DefaultHost).To(Equal("sudolikeaboss://file"))
+		Expect(conf.Websocket.URI).To(Equal("ws://env/4"))
+		Expect(conf.Websocket.Origin).To(Equal("resource://onepassword-at-agilebits-dot-com"))
+		Expect(conf.Profile).To(Equal("flag"))
+
+		Expect(loaded.Sources["timeoutSecs"]).To(Equal("file " + configFile))
+		Expect(loaded.Sources["websocket.uri"]).To(Equal("env SUDOLIKEABOSS_WEBSOCKET_URI"))
+		Expect(loaded.Sources["websocket.origin"]).To(Equal("default"))
+		Expect(loaded.Sources["profile"]).To(Equal("flag --profile"))
+	})
+
+	It("should read a JSON config file from the state directory", func() {
+		writeConfig(stateDir, "config.json", `{"unsafeLogging": true, "timeoutSecs": 5, "websocket": {"origin": "json"}}`)
+
+		loaded, err := loadConfiguration(nil, "")
+		Expect(err).To(BeNil())
+
+		Expect(loaded.Configuration.UnsafeLogging).To(BeTrue())
+		Expect(loaded.Configuration.TimeoutSecs).To(Equal(5))
+		Expect(loaded.Configuration.Websocket.Origin).To(Equal("json"))
+	})
+
+	It("should read a YAML config file given explicitly", func() {
+		configFile := writeConfig(xdgDir, "custom.yaml", "secret-storage: keyring\nwebsocket:\n  protocol: yaml\n")
+
+		loaded, err := loadConfiguration(nil, configFile)
+		Expect(err).To(BeNil())
+
+		Expect(loaded.Configuration.SecretStorage).To(Equal("keyring"))
+		Expect(loaded.Configuration.Websocket.Protocol).To(Equal("yaml"))
+	})
+
+	It("should reject unknown settings and wrong types", func() {
+		configFile := writeConfig(stateDir, "config.json", `{"websocketUrl": "ws://typo/4"}`)
+		_, err := loadConfiguration(nil, configFile)
+		Expect(err).To(MatchError(ContainSubstring(`unknown setting "websocketUrl"`)))
+
+		configFile = writeConfig(stateDir, "config.json", `{"timeoutSecs": "soon"}`)
+		_, err = loadConfiguration(nil, configFile)
+		Expect(err).To(MatchError(ContainSubstring("timeoutSecs")))
+	})
+
+	It("should show where each value came from", func() {
+		configFile := writeConfig(stateDir, "config.json", `{"defaultHost": "sudolikeaboss://file"}`)
+
+		loaded, err := loadConfiguration(nil, "")
+		Expect(err).To(BeNil())
+
+		var out bytes.Buffer
+		Expect(printConfiguration(&out, loaded)).To(Succeed())
+
+		Expect(out.String()).To(ContainSubstring("Config file: " + configFile))
+		Expect(out.String()).To(MatchRegexp(`defaultHost\s+"sudolikeaboss://file"\s+file ` + configFile))
+		Expect(out.String()).To(MatchRegexp(`timeoutSecs\s+30\s+default`))
+	})
+})
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+)
+
+// Exit codes, so wrappers can tell failures apart. 2 is left for usage errors.
+const (
+	exitOK                = 0
+	exitError             = 1
+	exitUsage             = 2
+	exitNotRegistered     = 3
+	exitHelperUnreachable = 4
+	exitAuthRejected      = 5
+	exitUserCancelled     = 6
+	exitTimeout           = 7
+	exitNotFound          = 8
+)
+
+// exitCode maps err to the exit code sudolikeaboss exits with.
+func exitCode(err error) int {
+	var fieldErr *onepass.FieldNotFoundError
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, onepass.ErrNotRegistered):
+		return exitNotRegistered
+	case errors.Is(err, onepass.ErrHelperUnreachable):
+		return exitHelperUnreachable
+	case errors.Is(err, onepass.ErrAuthRejected):
+		return exitAuthRejected
+	case errors.Is(err, onepass.ErrUserCancelled):
+		return exitUserCancelled
+	case errors.Is(err, onepass.ErrTimeout):
+		return exitTimeout
+	case errors.Is(err, onepass.ErrItemNotFound), errors.As(err, &fieldErr):
+		return exitNotFound
+	}
+
+	return exitError
+}
+
+// errorMessage explains err to the person at the terminal.
+func errorMessage(err error) string {
+	switch {
+	case errors.Is(err, onepass.ErrNotRegistered):
+		return "sudolikeaboss is not registered with 1Password, run `sudolikeaboss register` first"
+	case errors.Is(err, onepass.ErrHelperUnreachable):
+		return fmt.Sprintf("%s, is 1Password running?", err)
+	case errors.Is(err, onepass.ErrItemLookupUnsupported):
+		return fmt.Sprintf("%s, pick the item in the popup instead", err)
+	}
+
+	return err.Error()
+}
+
+// fail prints err to stderr and exits with its exit code.
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "sudolikeaboss: %s\n", errorMessage(err))
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Errors", func() {
+	It("should map errors to exit codes", func() {
+		Expect(exitCode(nil)).To(Equal(exitOK))
+		Expect(exitCode(errors.New("boom"))).To(Equal(exitError))
+		Expect(exitCode(onepass.ErrNotRegistered)).To(Equal(exitNotRegistered))
+		Expect(exitCode(fmt.Errorf("%w: refused", onepass.ErrHelperUnreachable))).To(Equal(exitHelperUnreachable))
+		Expect(exitCode(fmt.Errorf("%w: nope", onepass.ErrAuthRejected))).To(Equal(exitAuthRejected))
+		Expect(exitCode(onepass.ErrUserCancelled)).To(Equal(exitUserCancelled))
+		Expect(exitCode(onepass.ErrTimeout)).To(Equal(exitTimeout))
+		Expect(exitCode(onepass.ErrItemNotFound)).To(Equal(exitNotFound))
+		Expect(exitCode(onepass.ErrNoPassword)).To(Equal(exitNotFound))
+		Expect(exitCode(&onepass.FieldNotFoundError{Name: "totp"})).To(Equal(exitNotFound))
+	})
+
+	It("should tell the user how to register", func() {
+		Expect(errorMessage(onepass.ErrNotRegistered)).To(ContainSubstring("sudolikeaboss register"))
+	})
+})
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+)
+
+// forwardedSignals are passed on to the child of the exec command.
+var forwardedSignals = []os.Signal{
+	syscall.SIGINT,
+	syscall.SIGTERM,
+	syscall.SIGHUP,
+	syscall.SIGQUIT,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+}
+
+// terminalSignals are sent by the terminal to its whole foreground process
+// group. The child stays in ours so it can read the terminal, and already
+// gets them, so they aren't forwarded when running in a terminal.
+var terminalSignals = []os.Signal{
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+}
+
+// forwardSignal tells whether sig has to be passed on to the child.
+func forwardSignal(sig os.Signal, inTerminal bool) bool {
+	if !inTerminal {
+		return true
+	}
+	for _, s := range terminalSignals {
+		if s == sig {
+			return false
+		}
+	}
+	return true
+}
+
+// itemReference names an item on the command line or in a template. It is
+// one of
+//
+//	popup       the item picked in the 1Password popup
+//	uuid:UUID   the item with that uuid
+//	title:TITLE the item with that title
+//	TITLE       shorthand for title:TITLE
+//
+// The commands only resolve popup, see requirePopup.
+type itemReference struct {
+	Popup bool
+	UUID  string
+	Title string
+}
+
+func parseItemReference(s string) (*itemReference, error) {
+	var ref itemReference
+
+	switch {
+	case s == "popup":
+		ref.Popup = true
+	case strings.HasPrefix(s, "uuid:"):
+		ref.UUID = strings.TrimPrefix(s, "uuid:")
+	default:
+		ref.Title = strings.TrimPrefix(s, "title:")
+	}
+
+	if !ref.Popup && ref.UUID == "" && ref.Title == "" {
+		return nil, fmt.Errorf("invalid item reference %q", s)
+	}
+
+	return &ref, nil
+}
+
+// errPopupOnly rejects references the command can't resolve with the popup.
+// Looking items up by uuid or title relies on the experimental getItem
+// command, which the 1Password helper doesn't answer.
+var errPopupOnly = errors.New("only popup is supported, the 1Password helper can't look up items by uuid or title")
+
+// requirePopup returns errPopupOnly unless ref is the popup.
+func (ref *itemReference) requirePopup() error {
+	if !ref.Popup {
+		return errPopupOnly
+	}
+	return nil
+}
+
+func (ref *itemReference) fetch() fetchItem {
+	if ref.Popup {
+		return showPopup
+	}
+	return getItem(ref.UUID, ref.Title)
+}
+
+// envReference maps an environment variable to a field of an item. It is
+// written NAME=ITEM[#FIELD] where ITEM is popup and FIELD defaults to
+// password.
+type envReference struct {
+	itemReference
+	Name  string
+	Field string
+}
+
+func parseEnvReference(s string) (*envReference, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid reference %q, expected NAME=ITEM[#FIELD]", s)
+	}
+
+	field := "password"
+	item := parts[1]
+
+	if i := strings.LastIndex(item, "#"); i >= 0 {
+		field = item[i+1:]
+		item = item[:i]
+		if field == "" || item == "" {
+			return nil, fmt.Errorf("invalid reference %q, expected NAME=ITEM[#FIELD]", s)
+		}
+	}
+
+	itemRef, err := parseItemReference(item)
+	if err != nil {
+		return nil, err
+	}
+	if err := itemRef.requirePopup(); err != nil {
+		return nil, fmt.Errorf("invalid reference %q: %w", s, err)
+	}
+
+	return &envReference{itemReference: *itemRef, Name: parts[0], Field: field}, nil
+}
+
+// resolveEnvironment looks up every reference in a single authenticated
+// session, showing the popup once per reference, and sends the resulting
+// NAME=value pairs on done.
+func resolveEnvironment(ctx context.Context, configuration *onepass.Configuration, refs []*envReference, done chan []string) {
+	client, err := onepass.NewClientWithConfig(configuration)
+	if err != nil {
+		fail(err)
+	}
+	defer client.Close()
+
+	_, err = client.Authenticate(ctx, false)
+	if err != nil {
+		fail(err)
+	}
+
+	env := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		response, err := ref.fetch()(ctx, client)
+		if err != nil {
+			fail(err)
+		}
+
+		value, err := response.GetField(ref.Field)
+		if err != nil {
+			fail(err)
+		}
+
+		env = append(env, ref.Name+"="+value)
+	}
+
+	done <- env
+}
+
+// runCommand runs args with env added to the current environment, forwarding
+// signals to it, and returns its exit code. The signals are caught even when
+// they aren't forwarded, so we outlive the child to report its exit code.
+func runCommand(args []string, env []string) (int, error) {
+	if len(args) == 0 {
+		return 0, errors.New("no command given")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+
+	inTerminal := terminal.IsTerminal(int(os.Stdin.Fd()))
+	go func() {
+		for sig := range signals {
+			if forwardSignal(sig, inTerminal) {
+				_ = cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	signal.Stop(signals)
+	close(signals)
+
+	return commandExitCode(err)
+}
+
+// commandExitCode turns the error of a finished command into its exit code,
+// 128 plus the signal if it was killed.
+func commandExitCode(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return 0, err
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+		if status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return status.ExitStatus(), nil
+	}
+
+	return 1, nil
+}
+
+func runSudolikeabossExec(refs []*envReference, args []string) {
+	done := make(chan []string, 1)
+
+	conf := LoadConfiguration()
+	oc, err := conf.onepassConfiguration()
+	if err != nil {
+		fail(err)
+	}
+
+	ctx, cancel := conf.timeoutContext()
+	defer cancel()
+
+	go resolveEnvironment(ctx, &oc, refs, done)
+
+	var env []string
+	select {
+	case env = <-done:
+	case <-ctx.Done():
+		fail(onepass.ErrTimeout)
+	}
+
+	code, err := runCommand(args, env)
+	if err != nil {
+		fail(err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"syscall"
+
+	"github.com/brycekahle/sudolikeaboss/onepass/fakehelper"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Exec", func() {
+	Describe("parseEnvReference", func() {
+		It("should parse a field", func() {
+			Expect(parseEnvReference("DB_USER=popup#username")).To(Equal(&envReference{
+				itemReference: itemReference{Popup: true},
+				Name:          "DB_USER",
+				Field:         "username",
+			}))
+		})
+
+		It("should parse the popup", func() {
+			Expect(parseEnvReference("PASSWORD=popup")).To(Equal(&envReference{
+				itemReference: itemReference{Popup: true},
+				Name:          "PASSWORD",
+				Field:         "password",
+			}))
+		})
+
+		It("should reject malformed references", func() {
+			for _, s := range []string{"", "NAME", "=title", "NAME=", "NAME=title#", "NAME=#field", "NAME=uuid:"} {
+				_, err := parseEnvReference(s)
+				Expect(err).ToNot(BeNil(), s)
+			}
+		})
+
+		It("should reject items named by uuid or title", func() {
+			for _, s := range []string{"NAME=prod db", "NAME=title:prod db", "NAME=uuid:abc#username"} {
+				_, err := parseEnvReference(s)
+				Expect(errors.Is(err, errPopupOnly)).To(BeTrue(), s)
+			}
+		})
+	})
+
+	Describe("runCommand", func() {
+		It("should pass the environment and exit code through", func() {
+			out := captureStdout(func() {
+				code, err := runCommand([]string{"sh", "-c", `printf %s "$SECRET"; exit 3`}, []string{"SECRET=hunter2"})
+				Expect(err).To(BeNil())
+				Expect(code).To(Equal(3))
+			})
+			Expect(out).To(Equal("hunter2"))
+		})
+
+		It("should report signals as 128+signal", func() {
+			code, err := runCommand([]string{"sh", "-c", "kill -TERM $$"}, nil)
+			Expect(err).To(BeNil())
+			Expect(code).To(Equal(128 + 15))
+		})
+
+		It("should fail when the command does not exist", func() {
+			_, err := runCommand([]string{"/does/not/exist"}, nil)
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("should leave the signals of the terminal to the terminal", func() {
+			Expect(forwardSignal(syscall.SIGINT, true)).To(BeFalse())
+			Expect(forwardSignal(syscall.SIGQUIT, true)).To(BeFalse())
+			Expect(forwardSignal(syscall.SIGTERM, true)).To(BeTrue())
+			Expect(forwardSignal(syscall.SIGINT, false)).To(BeTrue())
+		})
+	})
+
+	Describe("resolveEnvironment", func() {
+		var helper *testHelper
+
+		BeforeEach(func() {
+			helper = startRegisteredHelper(
+				&fakehelper.Item{UUID: "db", Title: "db", Username: "dbuser", Password: "dbpassword"},
+				&fakehelper.Item{UUID: "api", Title: "api", Password: "apikey"},
+			)
+		})
+
+		AfterEach(func() {
+			helper.Close()
+		})
+
+		It("should resolve every reference in one session", func() {
+			picks := []string{"db", "db", "api"}
+			helper.Popup = func(url string, vault *fakehelper.Vault) *fakehelper.Item {
+				item := vault.Find(picks[0], "")
+				picks = picks[1:]
+				return item
+			}
+
+			var refs []*envReference
+			for _, s := range []string{"DB_USER=popup#username", "DB_PASSWORD=popup", "API_KEY=popup"} {
+				ref, err := parseEnvReference(s)
+				Expect(err).To(BeNil())
+				refs = append(refs, ref)
+			}
+
+			done := make(chan []string, 1)
+			resolveEnvironment(context.Background(), helper.Config(), refs, done)
+
+			Expect(<-done).To(Equal([]string{"DB_USER=dbuser", "DB_PASSWORD=dbpassword", "API_KEY=apikey"}))
+		})
+	})
+})
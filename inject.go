@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/template"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+)
+
+// secretResolver looks up item fields for templates over a single
+// authenticated client.
+type secretResolver struct {
+	// ctx bounds the lookups, templates can't pass it to op.
+	ctx    context.Context
+	client *onepass.OnePasswordClient
+}
+
+func newSecretResolver(ctx context.Context, client *onepass.OnePasswordClient) *secretResolver {
+	return &secretResolver{ctx: ctx, client: client}
+}
+
+// op is the template function {{ op "popup" ["FIELD"] }}. It shows the popup
+// every time it is used and FIELD defaults to password.
+func (resolver *secretResolver) op(item string, field ...string) (string, error) {
+	if len(field) > 1 {
+		return "", fmt.Errorf("op takes at most one field, got %d", len(field))
+	}
+
+	name := "password"
+	if len(field) == 1 {
+		name = field[0]
+	}
+
+	ref, err := parseItemReference(item)
+	if err != nil {
+		return "", err
+	}
+	if err := ref.requirePopup(); err != nil {
+		return "", fmt.Errorf("op %q: %w", item, err)
+	}
+
+	response, err := ref.fetch()(resolver.ctx, resolver.client)
+	if err != nil {
+		return "", err
+	}
+
+	return response.GetField(name)
+}
+
+func (resolver *secretResolver) funcs() template.FuncMap {
+	return template.FuncMap{"op": resolver.op}
+}
+
+// parseTemplate parses text so syntax errors are reported before connecting
+// to 1Password.
+func parseTemplate(name string, text string) (*template.Template, error) {
+	placeholder := &secretResolver{}
+	return template.New(name).Option("missingkey=error").Funcs(placeholder.funcs()).Parse(text)
+}
+
+func executeTemplate(tmpl *template.Template, resolver *secretResolver) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Funcs(resolver.funcs()).Execute(&buf, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderTemplate(ctx context.Context, configuration *onepass.Configuration, tmpl *template.Template, done chan []byte) {
+	client, err := onepass.NewClientWithConfig(configuration)
+	if err != nil {
+		fail(err)
+	}
+	defer client.Close()
+
+	_, err = client.Authenticate(ctx, false)
+	if err != nil {
+		fail(err)
+	}
+
+	rendered, err := executeTemplate(tmpl, newSecretResolver(ctx, client))
+	if err != nil {
+		fail(err)
+	}
+
+	done <- rendered
+}
+
+// writeSecretFile atomically replaces path with data, readable only by the
+// current user.
+func writeSecretFile(path string, data []byte) error {
+	return onepass.WriteFileAtomic(path, data, 0600)
+}
+
+func runSudolikeabossInject(input string, output string) {
+	done := make(chan []byte, 1)
+
+	text, err := ioutil.ReadFile(input)
+	if err != nil {
+		fail(err)
+	}
+
+	tmpl, err := parseTemplate(input, string(text))
+	if err != nil {
+		fail(err)
+	}
+
+	conf := LoadConfiguration()
+	oc, err := conf.onepassConfiguration()
+	if err != nil {
+		fail(err)
+	}
+
+	ctx, cancel := conf.timeoutContext()
+	defer cancel()
+
+	go renderTemplate(ctx, &oc, tmpl, done)
+
+	var rendered []byte
+	select {
+	case rendered = <-done:
+	case <-ctx.Done():
+		fail(onepass.ErrTimeout)
+	}
+
+	if output == "" {
+		_, err = os.Stdout.Write(rendered)
+	} else {
+		err = writeSecretFile(output, rendered)
+	}
+	if err != nil {
+		fail(err)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+	"github.com/brycekahle/sudolikeaboss/onepass/fakehelper"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Inject", func() {
+	var (
+		helper *testHelper
+		client *onepass.OnePasswordClient
+	)
+
+	BeforeEach(func() {
+		helper = startRegisteredHelper(
+			&fakehelper.Item{UUID: "db", Title: "prod db", Username: "dbuser", Password: "dbpassword"},
+			&fakehelper.Item{UUID: "api", Title: "api", Password: "apikey"},
+		)
+
+		var err error
+		client, err = onepass.NewClientWithConfig(helper.Config())
+		Expect(err).To(BeNil())
+		_, err = client.Authenticate(context.Background(), false)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).To(Succeed())
+		helper.Close()
+	})
+
+	It("should fill in secrets", func() {
+		picks := []string{"db", "db", "api"}
+		helper.Popup = func(url string, vault *fakehelper.Vault) *fakehelper.Item {
+			item := vault.Find(picks[0], "")
+			picks = picks[1:]
+			return item
+		}
+
+		tmpl, err := parseTemplate("config", `user: {{ op "popup" "username" }}
+password: {{ op "popup" }}
+api: {{ op "popup" "password" }}
+`)
+		Expect(err).To(BeNil())
+
+		rendered, err := executeTemplate(tmpl, newSecretResolver(context.Background(), client))
+		Expect(err).To(BeNil())
+		Expect(string(rendered)).To(Equal("user: dbuser\npassword: dbpassword\napi: apikey\n"))
+	})
+
+	It("should reject items named by uuid or title", func() {
+		for _, item := range []string{"prod db", "title:prod db", "uuid:api"} {
+			tmpl, err := parseTemplate("config", `{{ op "`+item+`" }}`)
+			Expect(err).To(BeNil())
+
+			_, err = executeTemplate(tmpl, newSecretResolver(context.Background(), client))
+			Expect(errors.Is(err, errPopupOnly)).To(BeTrue(), item)
+		}
+	})
+
+	It("should fail when the popup is closed", func() {
+		helper.Popup = func(string, *fakehelper.Vault) *fakehelper.Item { return nil }
+
+		tmpl, err := parseTemplate("config", `{{ op "popup" }}`)
+		Expect(err).To(BeNil())
+
+		_, err = executeTemplate(tmpl, newSecretResolver(context.Background(), client))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should report syntax errors before connecting", func() {
+		_, err := parseTemplate("config", `{{ op "popup" `)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should write the output readable only by the user", func() {
+		output := path.Join(helper.StateDir, "config.yml")
+		Expect(ioutil.WriteFile(output, []byte("old"), 0644)).To(Succeed())
+
+		Expect(writeSecretFile(output, []byte("new"))).To(Succeed())
+
+		info, err := os.Stat(output)
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		Expect(ioutil.ReadFile(output)).To(Equal([]byte("new")))
+	})
+})
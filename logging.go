@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// setupLogging routes logrus to stderr or logFile. Nothing is logged unless
+// one of verbose, debug or logFile is set, because as an iTerm coprocess our
+// output is typed into the terminal. debug enables the protocol traces,
+// which onepass.Logger redacts.
+func setupLogging(verbose bool, debug bool, logFile string) error {
+	var output io.Writer = ioutil.Discard
+	level := log.WarnLevel
+
+	if verbose || logFile != "" {
+		level = log.InfoLevel
+	}
+	if debug {
+		level = log.DebugLevel
+	}
+
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		output = f
+	} else if verbose || debug {
+		output = os.Stderr
+	}
+
+	log.SetOutput(output)
+	log.SetLevel(level)
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Logging", func() {
+	AfterEach(func() {
+		log.SetOutput(ioutil.Discard)
+		log.SetLevel(log.InfoLevel)
+	})
+
+	It("should log to stderr when verbose", func() {
+		Expect(setupLogging(true, false, "")).To(Succeed())
+		Expect(log.GetLevel()).To(Equal(log.InfoLevel))
+		Expect(log.StandardLogger().Out).To(Equal(os.Stderr))
+	})
+
+	It("should enable debug traces", func() {
+		Expect(setupLogging(false, true, "")).To(Succeed())
+		Expect(log.GetLevel()).To(Equal(log.DebugLevel))
+	})
+
+	It("should discard logs by default", func() {
+		Expect(setupLogging(false, false, "")).To(Succeed())
+		Expect(log.StandardLogger().Out).To(Equal(ioutil.Discard))
+	})
+
+	It("should write to the log file", func() {
+		dir, err := ioutil.TempDir("", "sudolikeaboss")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		logFile := path.Join(dir, "sudolikeaboss.log")
+		Expect(setupLogging(false, false, logFile)).To(Succeed())
+		log.Info("hello")
+
+		Expect(ioutil.ReadFile(logFile)).To(ContainSubstring("hello"))
+
+		info, err := os.Stat(logFile)
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+})
@@ -1,29 +1,18 @@
 package main
 
-/*
-#cgo CFLAGS: -x objective-c
-#cgo LDFLAGS: -framework Cocoa
-#import <Cocoa/Cocoa.h>
-int
-StartApp(void) {
-	[NSAutoreleasePool new];
-	[NSApplication sharedApplication];
-	[NSApp setActivationPolicy:NSApplicationActivationPolicyProhibited];
-	[NSApp run];
-	return 0;
-}
-*/
-import "C"
-
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"io/ioutil"
 
 	"github.com/urfave/cli"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
 )
 
 // Version is the SHA of the git commit from which this binary was built.
@@ -37,9 +26,89 @@ func main() {
 	app.Name = "sudolikeaboss"
 	app.Version = Version
 	app.Usage = "use 1password from the terminal with ease"
-	app.Action = func(c *cli.Context) {
-		go runSudolikeaboss()
-		C.StartApp()
+	fieldFlag := cli.StringFlag{
+		Name:  "field",
+		Value: "password",
+		Usage: "field of the item to print: password, username, notes, totp, htmlForm.<key> or a field id/name",
+	}
+
+	outputFlag := cli.StringFlag{
+		Name:  "output, o",
+		Usage: "output format: " + strings.Join(outputFormats, ", ") + ", the field on its own line if omitted",
+	}
+
+	app.Flags = []cli.Flag{
+		fieldFlag,
+		outputFlag,
+		cli.BoolFlag{
+			Name:  "verbose, v",
+			Usage: "log to stderr",
+		},
+		cli.BoolFlag{
+			Name:  "debug",
+			Usage: "log the protocol traces to stderr, with secrets redacted unless SUDOLIKEABOSS_UNSAFE_LOGGING=true",
+		},
+		cli.StringFlag{
+			Name:  "profile",
+			Usage: "use the named registration and helper settings, see `sudolikeaboss profiles`",
+		},
+		cli.BoolFlag{
+			Name:  "watch, w",
+			Usage: "wait for a sudo, ssh or password prompt on stdin before showing the popup, for iTerm coprocesses",
+		},
+		cli.StringFlag{
+			Name:  "prompt",
+			Usage: "prompt text to match the URL rules against",
+		},
+		cli.StringFlag{
+			Name:  "sink",
+			Value: sinkStdout,
+			Usage: "where the secret goes: " + strings.Join(sinkNames, ", "),
+		},
+		cli.DurationFlag{
+			Name:  "clear-after",
+			Value: 30 * time.Second,
+			Usage: "how long the clipboard sink keeps the secret, sudolikeaboss waits that long before exiting, 0 to keep it and exit right away",
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "read settings from this TOML, JSON or YAML file",
+			EnvVar: "SUDOLIKEABOSS_CONFIG",
+		},
+		cli.StringFlag{
+			Name:   "log-file",
+			Usage:  "log to this file instead of stderr",
+			EnvVar: "SUDOLIKEABOSS_LOG_FILE",
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		err := setupLogging(c.Bool("verbose"), c.Bool("debug"), c.String("log-file"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), exitError)
+		}
+
+		for _, s := range settings {
+			if s.Flag != "" && c.IsSet(s.Flag) {
+				configFlags[s.Flag] = c.String(s.Flag)
+			}
+		}
+		configFileFlag = c.String("config")
+		promptContext.Prompt = c.String("prompt")
+		return nil
+	}
+	app.Action = func(c *cli.Context) error {
+		options, err := parseRetrieveOptions(c)
+		if err != nil {
+			return err
+		}
+
+		if c.Bool("watch") {
+			go runSudolikeabossWatch(options)
+		} else {
+			go runSudolikeaboss(showPopup, options)
+		}
+		startApp()
+		return nil
 	}
 
 	app.Commands = []cli.Command{
@@ -51,10 +120,256 @@ func main() {
 				fmt.Println("Authenticating sudolikeaboss...")
 				fmt.Println("")
 				go runSudolikeabossRegistration()
-				C.StartApp()
+				startApp()
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "shows the registration and whether the 1Password helper accepts it",
+			Action: func(c *cli.Context) {
+				go runSudolikeabossStatus()
+				startApp()
+			},
+		},
+		{
+			Name:  "unregister",
+			Usage: "wipes the registration, which has to be revoked in 1Password too",
+			Action: func(c *cli.Context) {
+				go runSudolikeabossUnregistration()
+				startApp()
+			},
+		},
+		{
+			Name:  "get",
+			Usage: "prints the password of an item without showing the popup (experimental)",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "uuid",
+					Usage: "uuid of the item",
+				},
+				cli.StringFlag{
+					Name:  "title",
+					Usage: "title of the item, used when no uuid is given",
+				},
+				fieldFlag,
+				outputFlag,
+			},
+			Action: func(c *cli.Context) error {
+				if c.String("uuid") == "" && c.String("title") == "" {
+					return cli.NewExitError("either --uuid or --title is required", exitUsage)
+				}
+
+				options, err := parseRetrieveOptions(c)
+				if err != nil {
+					return err
+				}
+
+				go runSudolikeaboss(getItem(c.String("uuid"), c.String("title")), options)
+				startApp()
+				return nil
+			},
+		},
+		{
+			Name:      "exec",
+			Usage:     "runs a command with secrets from 1Password in its environment",
+			ArgsUsage: "-- command [args...]",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "env, e",
+					Usage: "NAME=popup[#FIELD], the field of the item picked in the popup",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return cli.NewExitError("a command to run is required", exitUsage)
+				}
+
+				var refs []*envReference
+				for _, s := range c.StringSlice("env") {
+					ref, err := parseEnvReference(s)
+					if err != nil {
+						return cli.NewExitError(err.Error(), exitUsage)
+					}
+					refs = append(refs, ref)
+				}
+
+				go runSudolikeabossExec(refs, c.Args())
+				startApp()
+				return nil
+			},
+		},
+		{
+			Name:  "inject",
+			Usage: "renders a template, replacing {{ op \"popup\" \"FIELD\" }} with secrets from 1Password",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "in, i",
+					Usage: "template to render",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Usage: "file to write with 0600 permissions, stdout if omitted",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.String("in") == "" {
+					return cli.NewExitError("an input template is required", exitUsage)
+				}
+
+				go runSudolikeabossInject(c.String("in"), c.String("out"))
+				startApp()
+				return nil
+			},
+		},
+		{
+			Name:  "tmux",
+			Usage: "types a secret into a tmux pane, bind it with `bind-key P run-shell -b \"sudolikeaboss tmux\"`",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "item",
+					Value: "popup",
+					Usage: "only popup, the 1Password helper can't look up items by uuid or title",
+				},
+				fieldFlag,
+				cli.StringFlag{
+					Name:  "target, t",
+					Usage: "pane to type into, the current one if omitted",
+				},
+				cli.BoolFlag{
+					Name:  "send-keys",
+					Usage: "type with send-keys instead of a paste buffer, the secret is visible to ps while tmux runs",
+				},
+				cli.BoolFlag{
+					Name:  "enter",
+					Usage: "press enter afterwards",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				ref, err := parseItemReference(c.String("item"))
+				if err == nil {
+					err = ref.requirePopup()
+				}
+				if err != nil {
+					return cli.NewExitError(err.Error(), exitUsage)
+				}
+
+				go runSudolikeabossTmux(ref, c.String("field"), tmuxOptions{
+					Pane:     c.String("target"),
+					SendKeys: c.Bool("send-keys"),
+					Enter:    c.Bool("enter"),
+				})
+				startApp()
+				return nil
+			},
+		},
+		{
+			Name:      "shell",
+			Usage:     "runs a shell the pty sink can type into",
+			ArgsUsage: "[-- command [args...]]",
+			Action: func(c *cli.Context) {
+				runSudolikeabossShell(c.Args())
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "shows the configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:  "show",
+					Usage: "prints the effective configuration and where each value came from, the environment wins over the config file and --profile over both",
+					Action: func(c *cli.Context) error {
+						loaded, err := loadConfiguration(configFlags, configFileFlag)
+						if err != nil {
+							return cli.NewExitError(err.Error(), exitError)
+						}
+						return printConfiguration(os.Stdout, loaded)
+					},
+				},
+			},
+		},
+		{
+			Name:   "profiles",
+			Usage:  "lists the profiles selectable with --profile",
+			Action: func(c *cli.Context) error { return listProfiles(LoadConfiguration(), os.Stdout) },
+			Subcommands: []cli.Command{
+				{
+					Name:      "add",
+					Usage:     "adds a profile or changes its helper settings",
+					ArgsUsage: "NAME",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "uri",
+							Usage: "websocket URI of the 1Password helper",
+						},
+						cli.StringFlag{
+							Name:  "protocol",
+							Usage: "websocket protocol",
+						},
+						cli.StringFlag{
+							Name:  "origin",
+							Usage: "websocket origin",
+						},
+						cli.StringFlag{
+							Name:  "host",
+							Usage: "URL the popup is shown for",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return cli.NewExitError("a profile name is required", exitUsage)
+						}
+
+						return addProfile(LoadConfiguration(), c.Args().First(), onepass.Profile{
+							WebsocketURI:      c.String("uri"),
+							WebsocketProtocol: c.String("protocol"),
+							WebsocketOrigin:   c.String("origin"),
+							DefaultHost:       c.String("host"),
+						})
+					},
+				},
+				{
+					Name:      "remove",
+					Usage:     "removes a profile and its registration",
+					ArgsUsage: "NAME",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return cli.NewExitError("a profile name is required", exitUsage)
+						}
+
+						conf := LoadConfiguration()
+						secretStore, err := onepass.NewSecretStore(conf.SecretStorage, passphraseReader())
+						if err != nil {
+							return cli.NewExitError(err.Error(), exitError)
+						}
+						return removeProfile(conf, c.Args().First(), secretStore)
+					},
+				},
 			},
 		},
 	}
 
 	_ = app.Run(os.Args)
 }
+
+func parseRetrieveOptions(c *cli.Context) (retrieveOptions, error) {
+	options := retrieveOptions{
+		Field:  c.String("field"),
+		Output: c.String("output"),
+	}
+
+	if !isOutputFormat(options.Output) {
+		return options, cli.NewExitError(fmt.Sprintf("unknown output format %q", options.Output), exitUsage)
+	}
+	if (c.IsSet("field") || c.GlobalIsSet("field")) && !printsField(options.Output) {
+		return options, cli.NewExitError(fmt.Sprintf("--field can't be used with --output %s, which prints all the credentials", options.Output), exitUsage)
+	}
+
+	// The sink and the clearing delay are global flags
+	sink, err := newSink(c.GlobalString("sink"), c.GlobalDuration("clear-after"))
+	if err != nil {
+		return options, cli.NewExitError(err.Error(), exitUsage)
+	}
+	options.Sink = sink
+
+	return options, nil
+}
@@ -0,0 +1,180 @@
+// Package nativemessaging talks to the 1Password helper like a browser
+// extension does since 1Password 6.8.1: the helper is spawned and messages
+// are exchanged over its stdin and stdout, each prefixed with its length as
+// a 32-bit little-endian integer.
+package nativemessaging
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// MaxMessageSize bounds the messages read, so a broken helper can't make us
+// allocate gigabytes.
+const MaxMessageSize = 64 << 20
+
+// closeTimeout is how long Close waits for the helper to exit.
+const closeTimeout = 2 * time.Second
+
+// ErrNotConnected is returned when sending or receiving before Connect.
+var ErrNotConnected = errors.New("the native messaging helper is not running")
+
+// Client spawns the helper and implements onepass.WebsocketClient on top of
+// its stdio.
+type Client struct {
+	Command string
+	Args    []string
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *os.File
+}
+
+func NewClient(command string, args ...string) *Client {
+	return &Client{Command: command, Args: args}
+}
+
+// Connect starts the helper. Its stderr is passed through.
+func (client *Client) Connect() error {
+	cmd := exec.Command(client.Command, client.Args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	// Unlike StdoutPipe, Wait doesn't close this pipe, so Close can't pull it
+	// away from a Receive that is still reading
+	stdout, childStdout, err := os.Pipe()
+	if err != nil {
+		stdin.Close()
+		return err
+	}
+	cmd.Stdout = childStdout
+
+	err = cmd.Start()
+	childStdout.Close()
+	if err != nil {
+		stdout.Close()
+		return err
+	}
+
+	client.cmd = cmd
+	client.stdin = stdin
+	client.stdout = stdout
+	return nil
+}
+
+// Close stops the helper. Closing its stdin is the signal to exit, it is
+// killed if it doesn't. A pending Receive gets io.EOF once the helper is gone
+// and only then is stdout closed.
+func (client *Client) Close() error {
+	if client.cmd == nil {
+		return nil
+	}
+
+	cmd := client.cmd
+	client.cmd = nil
+	client.stdin.Close()
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case <-exited:
+	case <-time.After(closeTimeout):
+		_ = cmd.Process.Kill()
+		<-exited
+	}
+	return client.stdout.Close()
+}
+
+// Receive reads the next message into v, which is a *string, a *[]byte or
+// anything JSON can be decoded into.
+func (client *Client) Receive(v interface{}) error {
+	if client.stdout == nil {
+		return ErrNotConnected
+	}
+
+	message, err := ReadMessage(client.stdout)
+	if err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case *string:
+		*v = string(message)
+	case *[]byte:
+		*v = message
+	default:
+		return json.Unmarshal(message, v)
+	}
+	return nil
+}
+
+// Send writes v, a string or []byte holding JSON or anything that can be
+// encoded as JSON, as one message.
+func (client *Client) Send(v interface{}) error {
+	if client.stdin == nil {
+		return ErrNotConnected
+	}
+
+	var message []byte
+	switch v := v.(type) {
+	case string:
+		message = []byte(v)
+	case []byte:
+		message = v
+	default:
+		var err error
+		message, err = json.Marshal(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return WriteMessage(client.stdin, message)
+}
+
+// ReadMessage reads one length prefixed message from r.
+func ReadMessage(r io.Reader) ([]byte, error) {
+	var length uint32
+	err := binary.Read(r, binary.LittleEndian, &length)
+	if err != nil {
+		return nil, err
+	}
+
+	if length > MaxMessageSize {
+		return nil, fmt.Errorf("native message of %d bytes is too large", length)
+	}
+
+	message := make([]byte, length)
+	_, err = io.ReadFull(r, message)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// WriteMessage writes message to w with its length prefix.
+func WriteMessage(w io.Writer, message []byte) error {
+	if len(message) > MaxMessageSize {
+		return fmt.Errorf("native message of %d bytes is too large", len(message))
+	}
+
+	buf := make([]byte, 4+len(message))
+	binary.LittleEndian.PutUint32(buf, uint32(len(message)))
+	copy(buf[4:], message)
+
+	_, err := w.Write(buf)
+	return err
+}
@@ -2,22 +2,30 @@ package onepass
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	b64 "encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path"
+	"sync"
+	"time"
 
 	"github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/brycekahle/sudolikeaboss/transport"
 	"github.com/brycekahle/sudolikeaboss/websocketclient"
 )
 
+// connectTimeout bounds the first connection made by NewTransportClient.
+const connectTimeout = 10 * time.Second
+
+// itemLookupTimeout is how long SendGetItemCommand waits for a reply. Helpers
+// that don't know getItem never answer it.
+var itemLookupTimeout = 5 * time.Second
+
 type Command struct {
 	Action   string  `json:"action"`
 	Number   int     `json:"number,omitempty"`
@@ -42,8 +50,12 @@ type Payload struct {
 	Iv           string            `json:"iv,omitempty"`
 	Data         string            `json:"data,omitempty"`
 	Hmac         string            `json:"hmac,omitempty"`
+	UUID         string            `json:"uuid,omitempty"`
+	Title        string            `json:"title,omitempty"`
 }
 
+// WebsocketClient is the interface transports implemented before
+// transport.Transport. NewCustomClient still accepts it.
 type WebsocketClient interface {
 	Connect() error
 	Receive(v interface{}) error
@@ -57,41 +69,152 @@ type Configuration struct {
 	WebsocketOrigin   string `json:"websocketOrigin"`
 	DefaultHost       string `json:"defaultHost"`
 	StateDirectory    string `json:"stateDirectory"`
+	// UnsafeLogging logs secrets, session keys and items in the clear.
+	UnsafeLogging bool `json:"unsafeLogging"`
+	// SecretStore protects the pairing secret, plaintext when nil.
+	SecretStore SecretStore `json:"-"`
+	// StateStore persists the pairing state, state.json in StateDirectory
+	// when nil.
+	StateStore StateStore `json:"-"`
+	// Dialer connects the transport to the helper, the websocket at
+	// WebsocketURI when nil.
+	Dialer transport.Dialer `json:"-"`
+	// Backoff paces reconnecting after the connection is lost,
+	// DefaultBackoff when nil.
+	Backoff *Backoff `json:"-"`
 }
 
 type OnePasswordClient struct {
 	DefaultHost             string
-	websocketClient         WebsocketClient
+	dial                    transport.Dialer
 	StateDirectory          string
-	number                  int
 	extID                   string
 	secret                  []byte
 	cc                      []byte
 	cs                      []byte
-	sessionHmacK            []byte
-	sessionEncK             []byte
+	session                 *sessionKeys
 	base64urlWithoutPadding *b64.Encoding
+
+	// Logger masks secrets in the protocol traces unless it is made unsafe.
+	Logger *Logger
+
+	secretStore SecretStore
+	stateStore  StateStore
+	backoff     Backoff
+
+	// sessionLock is held for reading while encrypted commands are in
+	// flight, and for writing during the handshake, which replaces the
+	// session and must not be interleaved with other commands.
+	sessionLock sync.RWMutex
+	// unsolicited buffers the messages that aren't replies, for ReceiveJSON.
+	unsolicited chan *Response
+
+	// mu guards the fields below.
+	mu     sync.Mutex
+	conn   *connection
+	number int
+	closed bool
+}
+
+// sessionKeys are the keys of one authenticated session. A new handshake
+// makes new ones instead of changing them, so every reply is decrypted with
+// the keys of the command it answers.
+type sessionKeys struct {
+	encK  []byte
+	hmacK []byte
+}
+
+func (keys *sessionKeys) sign(dataToSign ...[]byte) []byte {
+	return HmacSha256(keys.hmacK, dataToSign...)
 }
 
 type StateFileConfig struct {
 	Secret string `json:"secret"`
 	ExtID  string `json:"extID"`
+	// Storage is the kind of SecretStore that sealed Secret. State files
+	// written before it existed are plaintext.
+	Storage string `json:"storage,omitempty"`
+}
+
+// ClientOption customizes a client before its state is loaded.
+type ClientOption func(client *OnePasswordClient)
+
+// WithStateStore persists the pairing state in store instead of state.json
+// in the state directory.
+func WithStateStore(store StateStore) ClientOption {
+	return func(client *OnePasswordClient) {
+		client.stateStore = store
+	}
+}
+
+// WithSecretStore protects the pairing secret with store. Plaintext state
+// files are migrated to it when they are loaded.
+func WithSecretStore(store SecretStore) ClientOption {
+	return func(client *OnePasswordClient) {
+		client.secretStore = store
+	}
 }
 
 func NewClientWithConfig(configuration *Configuration) (*OnePasswordClient, error) {
-	return NewClient(configuration.WebsocketURI, configuration.WebsocketProtocol, configuration.WebsocketOrigin, configuration.DefaultHost, configuration.StateDirectory)
+	var options []ClientOption
+	if configuration.SecretStore != nil {
+		options = append(options, WithSecretStore(configuration.SecretStore))
+	}
+	if configuration.StateStore != nil {
+		options = append(options, WithStateStore(configuration.StateStore))
+	}
+	if configuration.Backoff != nil {
+		options = append(options, WithBackoff(*configuration.Backoff))
+	}
+
+	var client *OnePasswordClient
+	var err error
+	if configuration.Dialer != nil {
+		client, err = NewTransportClient(configuration.Dialer, configuration.DefaultHost, configuration.StateDirectory, options...)
+	} else {
+		client, err = NewClient(configuration.WebsocketURI, configuration.WebsocketProtocol, configuration.WebsocketOrigin, configuration.DefaultHost, configuration.StateDirectory, options...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client.Logger.Unsafe = configuration.UnsafeLogging
+	return client, nil
 }
 
-func NewClient(websocketURI string, websocketProtocol string, websocketOrigin string, defaultHost string, stateDirectory string) (*OnePasswordClient, error) {
+func NewClient(websocketURI string, websocketProtocol string, websocketOrigin string, defaultHost string, stateDirectory string, options ...ClientOption) (*OnePasswordClient, error) {
 	websocketClient := websocketclient.NewClient(websocketURI, websocketProtocol, websocketOrigin)
-	return NewCustomClient(websocketClient, defaultHost, stateDirectory)
+	return NewTransportClient(websocketClient.Dial, defaultHost, stateDirectory, options...)
 }
 
-func NewCustomClient(websocketClient WebsocketClient, defaultHost string, stateDirectory string) (*OnePasswordClient, error) {
+// NewCustomClient talks to the helper through websocketClient.
+func NewCustomClient(websocketClient WebsocketClient, defaultHost string, stateDirectory string, options ...ClientOption) (*OnePasswordClient, error) {
+	dial := transport.FromClient(websocketClient)
+	if client, ok := websocketClient.(*websocketclient.Client); ok {
+		dial = client.Dial
+	}
+	return NewTransportClient(dial, defaultHost, stateDirectory, options...)
+}
+
+// NewTransportClient talks to the helper through the transport dial
+// connects.
+func NewTransportClient(dial transport.Dialer, defaultHost string, stateDirectory string, options ...ClientOption) (*OnePasswordClient, error) {
 	client := OnePasswordClient{
-		websocketClient: websocketClient,
-		DefaultHost:     defaultHost,
-		StateDirectory:  stateDirectory,
+		dial:           dial,
+		DefaultHost:    defaultHost,
+		StateDirectory: stateDirectory,
+		Logger:         NewLogger(log.StandardLogger()),
+		secretStore:    PlaintextSecretStore{},
+		backoff:        DefaultBackoff,
+		unsolicited:    make(chan *Response, unsolicitedBufferSize),
+	}
+
+	for _, option := range options {
+		option(&client)
+	}
+
+	if client.stateStore == nil {
+		client.stateStore = NewFileStateStore(stateDirectory)
 	}
 
 	base64urlWithoutPadding := b64.URLEncoding.WithPadding(b64.NoPadding)
@@ -103,7 +226,9 @@ func NewCustomClient(websocketClient WebsocketClient, defaultHost string, stateD
 		return nil, err
 	}
 
-	err = client.Connect()
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	err = client.Connect(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -111,39 +236,51 @@ func NewCustomClient(websocketClient WebsocketClient, defaultHost string, stateD
 	return &client, nil
 }
 
+// LoadOrSetupState loads the pairing state, or creates it if there is none.
+// The state store stays locked meanwhile, so concurrent clients all end up
+// with the same registration.
 func (client *OnePasswordClient) LoadOrSetupState() error {
-	stateFilePath := path.Join(client.StateDirectory, "state.json")
-	stateFileExists, err := Exists(stateFilePath)
+	unlock, err := client.stateStore.Lock()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	if stateFileExists {
-		stateFileStr, err := ioutil.ReadFile(stateFilePath)
-		if err != nil {
-			return err
-		}
+	stateFileConfig, err := client.stateStore.Load()
+	if err != nil {
+		return err
+	}
 
-		var stateFileConfig StateFileConfig
+	if stateFileConfig != nil {
+		storage := stateFileConfig.Storage
+		if storage == "" {
+			storage = PlaintextStorage
+		}
 
-		err = json.Unmarshal(stateFileStr, &stateFileConfig)
-		if err != nil {
-			return err
+		var store SecretStore = PlaintextSecretStore{}
+		switch storage {
+		case client.secretStore.Kind():
+			store = client.secretStore
+		case PlaintextStorage:
+		default:
+			errorMsg := fmt.Sprintf("State file uses %s secret storage but %s is configured", storage, client.secretStore.Kind())
+			return errors.New(errorMsg)
 		}
 
-		secret, err := client.base64urlWithoutPadding.DecodeString(stateFileConfig.Secret)
+		secret, err := store.Open(stateFileConfig.ExtID, stateFileConfig.Secret)
 		if err != nil {
 			return err
 		}
 
 		client.extID = stateFileConfig.ExtID
 		client.secret = secret
-	} else {
-		err := EnsureDir(client.StateDirectory)
-		if err != nil {
-			return err
-		}
 
+		// Migrate plaintext state files to the configured storage
+		if storage != client.secretStore.Kind() {
+			client.Logger.Debugf("Migrating the state file to %s secret storage", client.secretStore.Kind())
+			return client.saveState()
+		}
+	} else {
 		extIDBytes := uuid.NewV4()
 		extID := extIDBytes.String()
 		client.extID = extID
@@ -154,26 +291,104 @@ func (client *OnePasswordClient) LoadOrSetupState() error {
 		}
 		client.secret = secret
 
-		stateFileConfig := StateFileConfig{
-			ExtID:  extID,
-			Secret: client.base64urlWithoutPadding.EncodeToString(secret),
-		}
+		return client.saveState()
+	}
+	return nil
+}
 
-		stateFileStr, err := json.Marshal(&stateFileConfig)
-		if err != nil {
-			return err
-		}
+// saveState seals the secret with the configured store and saves the state.
+func (client *OnePasswordClient) saveState() error {
+	sealed, err := client.secretStore.Seal(client.extID, client.secret)
+	if err != nil {
+		return err
+	}
 
-		return ioutil.WriteFile(stateFilePath, stateFileStr, 0700)
+	stateFileConfig := StateFileConfig{
+		ExtID:   client.extID,
+		Secret:  sealed,
+		Storage: client.secretStore.Kind(),
+	}
+
+	return client.stateStore.Save(&stateFileConfig)
+}
+
+// Connect dials the helper, giving up when ctx is done. An earlier connection
+// is closed first.
+func (client *OnePasswordClient) Connect(ctx context.Context) error {
+	client.mu.Lock()
+	previous := client.conn
+	closed := client.closed
+	client.conn = nil
+	client.mu.Unlock()
+
+	if closed {
+		return ErrClosed
+	}
+	if previous != nil {
+		_ = previous.close()
+	}
+
+	transport, err := client.dial(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return timeoutError(err)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrHelperUnreachable, err)
+	}
+
+	conn := newConnection(transport, func() *Logger { return client.Logger }, client.receiveUnsolicited)
+
+	client.mu.Lock()
+	closed = client.closed
+	previous = client.conn
+	if !closed {
+		client.conn = conn
+	}
+	client.mu.Unlock()
+
+	if closed {
+		_ = conn.close()
+		return ErrClosed
+	}
+	// Another Connect may have finished meanwhile
+	if previous != nil {
+		_ = previous.close()
 	}
 	return nil
 }
 
-func (client *OnePasswordClient) Connect() error {
-	return client.websocketClient.Connect()
+// connection returns the current connection to the helper.
+func (client *OnePasswordClient) connection() (*connection, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.closed {
+		return nil, ErrClosed
+	}
+	if client.conn == nil {
+		return nil, errNotConnected
+	}
+	return client.conn, nil
 }
 
-func (client *OnePasswordClient) SendShowPopupCommand() (*Response, error) {
+func (client *OnePasswordClient) isClosed() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	return client.closed
+}
+
+// receiveUnsolicited keeps a message that isn't a reply for ReceiveJSON,
+// dropping it when nobody has been reading them.
+func (client *OnePasswordClient) receiveUnsolicited(response *Response) {
+	select {
+	case client.unsolicited <- response:
+	default:
+		client.Logger.Debugf("Dropping the unsolicited message %s", response.Action)
+	}
+}
+
+func (client *OnePasswordClient) SendShowPopupCommand(ctx context.Context) (*Response, error) {
 	payload := Payload{
 		URL:     client.DefaultHost,
 		Options: map[string]string{"source": "toolbar-button"},
@@ -181,16 +396,79 @@ func (client *OnePasswordClient) SendShowPopupCommand() (*Response, error) {
 
 	command := client.createCommand("showPopup", payload)
 
-	response, err := client.SendEncryptedCommand(command)
+	response, err := client.SendEncryptedCommand(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+
+	switch response.Action {
+	case "fillItem":
+	case "popupClosed":
+		return nil, ErrUserCancelled
+	default:
+		errorMsg := fmt.Sprintf("Unexpected response: %s", response.Action)
+		err = errors.New(errorMsg)
+		return nil, err
+	}
+
+	return client.decryptResponsePayload(response)
+}
+
+// SendGetItemCommand asks the helper for a single item by UUID or, when
+// itemUUID is empty, by title. Unlike showPopup no user interaction is needed.
+//
+// getItem is experimental. It isn't part of any documented version of the
+// helper protocol and only fakehelper is known to answer it. Any reply but an
+// item or itemNotFound, or none within itemLookupTimeout, gives an error
+// wrapping ErrItemLookupUnsupported.
+func (client *OnePasswordClient) SendGetItemCommand(ctx context.Context, itemUUID string, title string) (*Response, error) {
+	if itemUUID == "" && title == "" {
+		return nil, errors.New("an item uuid or title is required")
+	}
+
+	payload := Payload{
+		URL:   client.DefaultHost,
+		UUID:  itemUUID,
+		Title: title,
+	}
+
+	command := client.createCommand("getItem", payload)
+
+	lookupCtx, cancel := context.WithTimeout(ctx, itemLookupTimeout)
+	defer cancel()
+
+	response, err := client.SendEncryptedCommand(lookupCtx, command)
+	if errors.Is(err, ErrTimeout) && ctx.Err() == nil {
+		return nil, fmt.Errorf("%w: no reply within %s", ErrItemLookupUnsupported, itemLookupTimeout)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	switch response.Action {
+	case "fillItem":
+	case "itemNotFound":
+		return nil, ErrItemNotFound
+	default:
+		return nil, fmt.Errorf("%w: unexpected response %s", ErrItemLookupUnsupported, response.Action)
+	}
+
+	return client.decryptResponsePayload(response)
+}
+
+// decryptResponsePayload replaces the encrypted payload of response with the
+// decrypted one.
+func (client *OnePasswordClient) decryptResponsePayload(response *Response) (*Response, error) {
+	// Close wipes the keys under the write lock
+	client.sessionLock.RLock()
 	decryptedPayloadRaw, err := client.decryptResponse(response)
+	client.sessionLock.RUnlock()
 	if err != nil {
 		return nil, err
 	}
 
+	client.Logger.Debugf("Decrypted: %s", client.Logger.Mask(ItemContents, string(decryptedPayloadRaw)))
+
 	var decryptedPayload ResponsePayload
 
 	err = json.Unmarshal(decryptedPayloadRaw, &decryptedPayload)
@@ -205,20 +483,29 @@ func (client *OnePasswordClient) SendShowPopupCommand() (*Response, error) {
 
 func (client *OnePasswordClient) createCommand(action string, payload Payload) *Command {
 	command := Command{
-		Action: action,
-		//Number:   client.number,
+		Action:  action,
+		Number:  client.nextNumber(),
 		Version: "4.6.2.90",
 		//BundleID: "com.sudolikeaboss.sudolikeaboss",
 		Payload: payload,
 	}
 
+	return &command
+}
+
+// nextNumber numbers a command, so its reply can be told apart from those of
+// concurrent commands. Numbers start at 1, 0 is left out of the JSON.
+func (client *OnePasswordClient) nextNumber() int {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
 	// Increment the number (it's a 1password thing that I saw whilst listening
 	// to their commands
 	client.number++
-	return &command
+	return client.number
 }
 
-func (client *OnePasswordClient) SendHelloCommand() (*Response, error) {
+func (client *OnePasswordClient) SendHelloCommand(ctx context.Context) (*Response, error) {
 	capabilities := make([]string, 2)
 	capabilities[0] = "auth-sma-hmac256"
 	capabilities[1] = "aead-cbchmac-256"
@@ -231,7 +518,7 @@ func (client *OnePasswordClient) SendHelloCommand() (*Response, error) {
 
 	command := client.createCommand("hello", payload)
 
-	response, err := client.SendCommand(command)
+	response, err := client.SendCommand(ctx, command)
 	if err != nil {
 		return nil, err
 	}
@@ -245,15 +532,94 @@ func (client *OnePasswordClient) SendHelloCommand() (*Response, error) {
 	return response, nil
 }
 
-func (client *OnePasswordClient) hmacSignWithSecret(dataToSign ...[]byte) []byte {
-	return HmacSha256(client.secret, dataToSign...)
+// Probe says hello to the helper as extID without loading or creating any
+// pairing state, and returns the action it answers with: authBegin when it
+// knows the registration, authNew when it doesn't. It fails with an error
+// wrapping ErrHelperUnreachable when the helper can't be reached.
+func Probe(ctx context.Context, configuration *Configuration, extID string) (string, error) {
+	dial := configuration.Dialer
+	if dial == nil {
+		dial = websocketclient.NewClient(configuration.WebsocketURI, configuration.WebsocketProtocol, configuration.WebsocketOrigin).Dial
+	}
+
+	client := OnePasswordClient{
+		dial:        dial,
+		extID:       extID,
+		Logger:      NewLogger(log.StandardLogger()),
+		backoff:     DefaultBackoff,
+		unsolicited: make(chan *Response, unsolicitedBufferSize),
+	}
+	client.Logger.Unsafe = configuration.UnsafeLogging
+	defer client.Close()
+
+	err := client.Connect(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := client.SendHelloCommand(ctx)
+	if err != nil {
+		return "", err
+	}
+	return response.Action, nil
 }
 
-func (client *OnePasswordClient) hmacSignWithSession(dataToSign ...[]byte) []byte {
-	return HmacSha256(client.sessionHmacK, dataToSign...)
+// Close closes the connection to the helper and wipes the pairing secret and
+// session keys from memory. The client fails with ErrClosed afterwards.
+func (client *OnePasswordClient) Close() error {
+	client.mu.Lock()
+	if client.closed {
+		client.mu.Unlock()
+		return nil
+	}
+	client.closed = true
+	conn := client.conn
+	client.conn = nil
+	client.mu.Unlock()
+
+	// Closing the connection fails the commands in flight, so the keys can
+	// be wiped once they let go of them
+	var err error
+	if conn != nil {
+		err = conn.close()
+	}
+
+	client.sessionLock.Lock()
+	client.wipeKeys()
+	client.sessionLock.Unlock()
+	return err
+}
+
+// wipeKeys needs the session lock held for writing.
+func (client *OnePasswordClient) wipeKeys() {
+	zero(client.secret)
+	client.secret = nil
+
+	client.wipeSession()
+}
+
+// wipeSession zeroes the keys of the current session, once it is over. It
+// needs the session lock held for writing.
+func (client *OnePasswordClient) wipeSession() {
+	if client.session != nil {
+		zero(client.session.encK)
+		zero(client.session.hmacK)
+		client.session = nil
+	}
+}
+
+// zero overwrites secret key material that is no longer needed.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func (client *OnePasswordClient) hmacSignWithSecret(dataToSign ...[]byte) []byte {
+	return HmacSha256(client.secret, dataToSign...)
 }
 
-func (client *OnePasswordClient) authRegister() (*Response, error) {
+func (client *OnePasswordClient) authRegister(ctx context.Context) (*Response, error) {
 	secretB64 := b64.URLEncoding.EncodeToString(client.secret)
 
 	authRegisterPayload := Payload{
@@ -264,21 +630,19 @@ func (client *OnePasswordClient) authRegister() (*Response, error) {
 
 	authRegisterCommand := client.createCommand("authRegister", authRegisterPayload)
 
-	registerResponse, err := client.SendCommand(authRegisterCommand)
+	registerResponse, err := client.SendCommand(ctx, authRegisterCommand)
 	if err != nil {
 		return nil, err
 	}
 
 	if registerResponse.Action != "authRegistered" {
-		errorMsg := fmt.Sprintf("Unexpected response: %s", registerResponse.Action)
-		err = errors.New(errorMsg)
-		return nil, err
+		return nil, authRejected(registerResponse.Action)
 	}
 
 	return registerResponse, nil
 }
 
-func (client *OnePasswordClient) authBegin(cc []byte) (*Response, error) {
+func (client *OnePasswordClient) authBegin(ctx context.Context, cc []byte) (*Response, error) {
 	ccB64 := client.base64urlWithoutPadding.EncodeToString(cc)
 
 	authBeginPayload := Payload{
@@ -289,15 +653,13 @@ func (client *OnePasswordClient) authBegin(cc []byte) (*Response, error) {
 
 	authBeginCommand := client.createCommand("authBegin", authBeginPayload)
 
-	authBeginResponse, err := client.SendCommand(authBeginCommand)
+	authBeginResponse, err := client.SendCommand(ctx, authBeginCommand)
 	if err != nil {
 		return nil, err
 	}
 
 	if authBeginResponse.Action != "authContinue" {
-		errorMsg := fmt.Sprintf("Unexpected response: %s", authBeginResponse.Action)
-		err = errors.New(errorMsg)
-		return nil, err
+		return nil, authRejected(authBeginResponse.Action)
 	}
 
 	return authBeginResponse, nil
@@ -327,7 +689,7 @@ func (client *OnePasswordClient) generateHmacK(m3 []byte, m4 []byte) []byte {
 }
 
 func (client *OnePasswordClient) signMessageHmac(iv []byte, data []byte, adata []byte) []byte {
-	return client.hmacSignWithSession(iv, data)
+	return client.session.sign(iv, data)
 }
 
 func (client *OnePasswordClient) Debug(secretB64 string, csB64 string, ccB64 string, m3B64 string, m4B64 string, encKB64 string, hmacKB64 string, ivB64 string, plaintext string, adata string, ciphertextB64 string, hmacB64 string) {
@@ -342,10 +704,8 @@ func (client *OnePasswordClient) Debug(secretB64 string, csB64 string, ccB64 str
 	m4, _ := client.base64urlWithoutPadding.DecodeString(m4B64)
 
 	encK, _ := client.base64urlWithoutPadding.DecodeString(encKB64)
-	client.sessionEncK = encK
-
 	hmacK, _ := client.base64urlWithoutPadding.DecodeString(hmacKB64)
-	client.sessionHmacK = hmacK
+	client.session = &sessionKeys{encK: encK, hmacK: hmacK}
 
 	//iv, _ := client.base64urlWithoutPadding.DecodeString(ivB64)
 
@@ -355,12 +715,12 @@ func (client *OnePasswordClient) Debug(secretB64 string, csB64 string, ccB64 str
 
 	// Generate M3
 	if !bytes.Equal(client.generateM3(cs, cc), m3) {
-		log.Printf("Bad M3 logic")
+		client.Logger.Debugf("Bad M3 logic")
 	}
 
 	// Generate M4
 	if !bytes.Equal(client.generateM4(m3), m4) {
-		log.Printf("Bad M4 logic")
+		client.Logger.Debugf("Bad M4 logic")
 	}
 
 	// Generate EncK
@@ -368,27 +728,28 @@ func (client *OnePasswordClient) Debug(secretB64 string, csB64 string, ccB64 str
 	//log.Printf("Bad EncK Logic")
 	//}
 
-	client.sessionEncK = client.generateEncK(m3, m4)
-
 	//if bytes.Compare(client.generateHmacK(m3, m4), hmacK) != 0 {
 	//log.Printf("Bad HmacK Logic")
 	//}
-	client.sessionHmacK = client.generateHmacK(m3, m4)
+	client.session = &sessionKeys{
+		encK:  client.generateEncK(m3, m4),
+		hmacK: client.generateHmacK(m3, m4),
+	}
 
 	generatedHmac := client.signMessageHmac([]byte(ivB64), []byte(ciphertextB64), []byte(adata))
 
 	if !bytes.Equal(generatedHmac, hmac) {
-		log.Printf("Bad Hmac Session Logic")
-		log.Printf("%s != %s", client.base64urlWithoutPadding.EncodeToString(generatedHmac), client.base64urlWithoutPadding.EncodeToString(hmac))
+		client.Logger.Debugf("Bad Hmac Session Logic")
+		client.Logger.Debugf("%s != %s", client.Logger.MaskBytes(Ciphertext, generatedHmac), client.Logger.MaskBytes(Ciphertext, hmac))
 	}
 
-	log.Printf("Done")
+	client.Logger.Debugf("Done")
 }
 
-func (client *OnePasswordClient) Register(code string) (*Response, error) {
+func (client *OnePasswordClient) Register(ctx context.Context, code string) (*Response, error) {
 	fmt.Printf("The 1password helper will request registration of code: %s\n", code)
 	fmt.Println("To complete registration. You must accept that code from the helper.")
-	_, err := client.authRegister()
+	_, err := client.authRegister(ctx)
 
 	if err != nil {
 		fmt.Printf("Registration failed with %s\n", err)
@@ -398,22 +759,42 @@ func (client *OnePasswordClient) Register(code string) (*Response, error) {
 	return nil, nil
 }
 
-func (client *OnePasswordClient) Authenticate(register bool) (*Response, error) {
-	helloResponse, err := client.SendHelloCommand()
+// Authenticate does the handshake with the helper, registering first when
+// register is set. Without registering, the handshake is redone once on a new
+// connection when the connection is lost.
+func (client *OnePasswordClient) Authenticate(ctx context.Context, register bool) (*Response, error) {
+	client.sessionLock.Lock()
+	defer client.sessionLock.Unlock()
+
+	response, err := client.authenticate(ctx, register)
+	if register || !client.lostConnection(err) {
+		return response, err
+	}
+
+	err = client.reconnect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.authenticate(ctx, register)
+}
+
+func (client *OnePasswordClient) authenticate(ctx context.Context, register bool) (*Response, error) {
+	helloResponse, err := client.SendHelloCommand(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	if register {
 		if helloResponse.Action != "authNew" {
-			fmt.Println("sudolikeaboss is already registered.")
-			os.Exit(0)
+			return nil, ErrAlreadyRegistered
 		}
 
-		_, err = client.Register(helloResponse.Payload.Code)
+		_, err = client.Register(ctx, helloResponse.Payload.Code)
 		if err != nil {
 			return nil, err
 		}
+	} else if helloResponse.Action == "authNew" {
+		return nil, ErrNotRegistered
 	}
 
 	cc, err := GenerateRandomBytes(16)
@@ -421,7 +802,7 @@ func (client *OnePasswordClient) Authenticate(register bool) (*Response, error)
 		return nil, err
 	}
 
-	authBeginResponse, err := client.authBegin(cc)
+	authBeginResponse, err := client.authBegin(ctx, cc)
 	if err != nil {
 		return nil, err
 	}
@@ -437,9 +818,7 @@ func (client *OnePasswordClient) Authenticate(register bool) (*Response, error)
 	expectedM3Bytes := client.generateM3(cs, cc)
 
 	if !bytes.Equal(expectedM3Bytes, m3) {
-		errorMsg := fmt.Sprintf("M3 not expected value")
-		err = errors.New(errorMsg)
-		return nil, err
+		return nil, fmt.Errorf("%w: M3 not expected value", ErrAuthRejected)
 	}
 
 	m4 := client.generateM4(m3)
@@ -453,37 +832,44 @@ func (client *OnePasswordClient) Authenticate(register bool) (*Response, error)
 
 	authVerifyCommand := client.createCommand("authVerify", authVerifyPayload)
 
-	authVerifyResponse, err := client.SendCommand(authVerifyCommand)
+	authVerifyResponse, err := client.SendCommand(ctx, authVerifyCommand)
 	if err != nil {
 		return nil, err
 	}
 
 	if authVerifyResponse.Action != "welcome" {
-		errorMsg := fmt.Sprintf("Unexpected response: %s", authVerifyResponse.Action)
-		err = errors.New(errorMsg)
-		return nil, err
+		return nil, authRejected(authVerifyResponse.Action)
 	}
 
 	// Generate the keys
 	//
 	// encK = HMAC-SHA256(secret, M3|M4|"encryption")
-	client.sessionEncK = client.generateEncK(m3, m4)
-
 	// hmacK = HMAC-SHA256(secret, M4|M3|"hmac")
-	client.sessionHmacK = client.generateHmacK(m3, m4)
+	client.session = &sessionKeys{
+		encK:  client.generateEncK(m3, m4),
+		hmacK: client.generateHmacK(m3, m4),
+	}
 
-	log.Printf("hmacK = %s", client.base64urlWithoutPadding.EncodeToString(client.sessionHmacK))
+	client.Logger.Debugf("hmacK = %s", client.Logger.MaskBytes(SessionKey, client.session.hmacK))
 
+	authVerifyResponse.session = client.session
 	decryptedPayload, err := client.decryptResponse(authVerifyResponse)
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("%s", b64.StdEncoding.EncodeToString(decryptedPayload))
+	client.Logger.Debugf("welcome = %s", client.Logger.Mask(ItemContents, string(decryptedPayload)))
 
 	return authVerifyResponse, nil
 }
 
+// decryptResponse decrypts the payload with the keys of the session the
+// response was received in.
 func (client *OnePasswordClient) decryptResponse(response *Response) ([]byte, error) {
+	keys := response.session
+	if keys == nil {
+		return nil, errors.New("the response wasn't received in an authenticated session")
+	}
+
 	iv, err := client.base64urlWithoutPadding.DecodeString(response.Payload.Iv)
 	if err != nil {
 		return nil, err
@@ -500,12 +886,12 @@ func (client *OnePasswordClient) decryptResponse(response *Response) ([]byte, er
 	}
 
 	// Verify hmac
-	expectedHmac := client.hmacSignWithSession([]byte(response.Payload.Iv), []byte(response.Payload.Data))
+	expectedHmac := keys.sign([]byte(response.Payload.Iv), []byte(response.Payload.Data))
 
-	log.Printf(
+	client.Logger.Debugf(
 		"%s == %s",
-		client.base64urlWithoutPadding.EncodeToString(hmac),
-		client.base64urlWithoutPadding.EncodeToString(expectedHmac),
+		client.Logger.MaskBytes(Ciphertext, hmac),
+		client.Logger.MaskBytes(Ciphertext, expectedHmac),
 	)
 
 	if !bytes.Equal(expectedHmac, hmac) {
@@ -515,12 +901,12 @@ func (client *OnePasswordClient) decryptResponse(response *Response) ([]byte, er
 	}
 
 	// Decrypt
-	payload, err := Decrypt(client.sessionEncK, iv, data)
+	payload, err := Decrypt(keys.encK, iv, data)
 
 	return payload, err
 }
 
-func (client *OnePasswordClient) encryptPayload(payload *Payload) (*Payload, error) {
+func (client *OnePasswordClient) encryptPayload(keys *sessionKeys, payload *Payload) (*Payload, error) {
 	iv, err := GenerateRandomBytes(16)
 	if err != nil {
 		return nil, err
@@ -533,7 +919,7 @@ func (client *OnePasswordClient) encryptPayload(payload *Payload) (*Payload, err
 	}
 
 	// Encrypt the payload
-	encryptedPayload, err := Encrypt(client.sessionEncK, iv, payloadJSONStr)
+	encryptedPayload, err := Encrypt(keys.encK, iv, payloadJSONStr)
 	if err != nil {
 		return nil, err
 	}
@@ -543,7 +929,7 @@ func (client *OnePasswordClient) encryptPayload(payload *Payload) (*Payload, err
 	// Generate HMAC for the message
 	ivB64 := client.base64urlWithoutPadding.EncodeToString(iv)
 
-	payloadHmac := client.hmacSignWithSession([]byte(ivB64), []byte(encryptedPayloadB64))
+	payloadHmac := keys.sign([]byte(ivB64), []byte(encryptedPayloadB64))
 
 	payloadHmacB64 := client.base64urlWithoutPadding.EncodeToString(payloadHmac)
 
@@ -557,73 +943,128 @@ func (client *OnePasswordClient) encryptPayload(payload *Payload) (*Payload, err
 	return &newPayload, nil
 }
 
-func (client *OnePasswordClient) SendCommand(command *Command) (*Response, error) {
-	jsonStr, err := json.Marshal(command)
+// SendCommand sends command and waits for the reply. Once the deadline of ctx
+// passes it gives up with an error wrapping ErrTimeout. It is safe to call
+// from several goroutines, the replies are matched to the commands by their
+// number.
+func (client *OnePasswordClient) SendCommand(ctx context.Context, command *Command) (*Response, error) {
+	conn, err := client.connection()
 	if err != nil {
 		return nil, err
 	}
 
-	err = client.SendJSON(jsonStr)
-	if err != nil {
-		return nil, err
+	if command.Number == 0 {
+		command.Number = client.nextNumber()
 	}
 
-	response, err := client.ReceiveJSON()
+	jsonStr, err := json.Marshal(command)
 	if err != nil {
 		return nil, err
 	}
 
-	return response, nil
+	response, err := conn.roundTrip(ctx, command.Action, command.Number, jsonStr)
+	if err != nil && client.isClosed() {
+		return nil, ErrClosed
+	}
+	return response, err
 }
 
-func (client *OnePasswordClient) SendEncryptedCommand(command *Command) (*Response, error) {
-	// Create the encrypted payload
+// SendEncryptedCommand encrypts the payload of command with the session keys,
+// sends it and waits for the reply. When the connection is lost meanwhile,
+// it reconnects, authenticates again and sends command once more.
+func (client *OnePasswordClient) SendEncryptedCommand(ctx context.Context, command *Command) (*Response, error) {
 	plaintextPayload := command.Payload
 
-	encryptedPayload, err := client.encryptPayload(&plaintextPayload)
+	response, conn, err := client.sendEncryptedCommand(ctx, command, plaintextPayload)
+	if !client.lostConnection(err) {
+		return response, err
+	}
+
+	err = client.recover(ctx, conn)
 	if err != nil {
 		return nil, err
 	}
 
-	command.Payload = *encryptedPayload
+	response, _, err = client.sendEncryptedCommand(ctx, command, plaintextPayload)
+	return response, err
+}
 
-	jsonStr, err := json.Marshal(command)
+// sendEncryptedCommand sends command in the current session and returns the
+// connection it used.
+func (client *OnePasswordClient) sendEncryptedCommand(ctx context.Context, command *Command, plaintextPayload Payload) (*Response, *connection, error) {
+	client.sessionLock.RLock()
+	defer client.sessionLock.RUnlock()
+
+	conn, err := client.connection()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	keys := client.session
+	if keys == nil {
+		return nil, conn, errors.New("the client isn't authenticated")
 	}
 
-	err = client.SendJSON(jsonStr)
+	// Create the encrypted payload
+	encryptedPayload, err := client.encryptPayload(keys, &plaintextPayload)
 	if err != nil {
-		return nil, err
+		return nil, conn, err
 	}
 
-	response, err := client.ReceiveJSON()
+	command.Payload = *encryptedPayload
+	if command.Number == 0 {
+		command.Number = client.nextNumber()
+	}
+
+	jsonStr, err := json.Marshal(command)
 	if err != nil {
-		return nil, err
+		return nil, conn, err
 	}
 
-	return response, nil
-}
+	response, err := conn.roundTrip(ctx, command.Action, command.Number, jsonStr)
+	if err != nil {
+		if client.isClosed() {
+			return nil, conn, ErrClosed
+		}
+		return nil, conn, err
+	}
 
-func (client *OnePasswordClient) SendJSON(jsonStr []byte) error {
-	log.Printf("Sending: %s", jsonStr)
-	return client.websocketClient.Send(jsonStr)
+	response.session = keys
+	return response, conn, nil
 }
 
-func (client *OnePasswordClient) ReceiveJSON() (*Response, error) {
-	var rawResponseStr string
-
-	err := client.websocketClient.Receive(&rawResponseStr)
+// SendJSON sends a message without waiting for a reply, see ReceiveJSON.
+func (client *OnePasswordClient) SendJSON(ctx context.Context, jsonStr []byte) error {
+	conn, err := client.connection()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	log.Printf("Received: %s", rawResponseStr)
+	return conn.send(ctx, jsonStr)
+}
 
-	response, err := LoadResponse(rawResponseStr)
+// ReceiveJSON waits for the next message from the helper that isn't the reply
+// to a command sent with SendCommand or SendEncryptedCommand.
+func (client *OnePasswordClient) ReceiveJSON(ctx context.Context) (*Response, error) {
+	conn, err := client.connection()
 	if err != nil {
 		return nil, err
 	}
 
-	return response, nil
+	select {
+	case response := <-client.unsolicited:
+		return response, nil
+	case <-conn.done:
+		select {
+		case response := <-client.unsolicited:
+			return response, nil
+		default:
+		}
+		if client.isClosed() {
+			return nil, ErrClosed
+		}
+		return nil, conn.failure()
+	case <-ctx.Done():
+		return nil, timeoutError(ctx.Err())
+	}
 }
@@ -0,0 +1,248 @@
+package onepass
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/brycekahle/sudolikeaboss/transport"
+)
+
+// unsolicitedBufferSize is how many messages that aren't replies are kept
+// for ReceiveJSON.
+const unsolicitedBufferSize = 16
+
+// replyActions lists the replies to the commands of the documented protocol
+// and to the experimental getItem. A reply without a number only goes to a
+// waiting command it is listed for, or to any command that isn't listed here.
+var replyActions = map[string][]string{
+	"hello":        {"authNew", "authBegin"},
+	"authRegister": {"authRegistered", "authFailed"},
+	"authBegin":    {"authContinue", "authFailed"},
+	"authVerify":   {"welcome", "authFailed"},
+	"showPopup":    {"fillItem", "popupClosed"},
+	"getItem":      {"fillItem", "itemNotFound"},
+}
+
+// answers reports whether a reply with action can answer the command
+// commandAction.
+func answers(commandAction string, action string) bool {
+	actions, ok := replyActions[commandAction]
+	if !ok {
+		return true
+	}
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// connection is one connection to the helper. A reader goroutine receives
+// every message and hands the replies to the commands waiting for them by
+// their number, so several commands can be in flight at once. The helper
+// doesn't number every reply, one without a number goes to the oldest
+// command still waiting if it answers that command. Messages that answer no
+// command are unsolicited.
+type connection struct {
+	transport transport.Transport
+	// logger returns the logger of the client, which may be swapped while
+	// connected.
+	logger      func() *Logger
+	unsolicited func(*Response)
+
+	// sendMu keeps messages from being interleaved on the transport.
+	sendMu sync.Mutex
+
+	// mu guards the fields below.
+	mu sync.Mutex
+	// pending holds the commands waiting for a reply by number.
+	pending map[int]*pendingCommand
+	// order lists the numbers in pending, oldest first.
+	order []int
+	// highest is the highest number sent. A numbered reply up to it that
+	// isn't pending is the late reply to a command that gave up.
+	highest int
+	err     error
+	done    chan struct{}
+}
+
+type pendingCommand struct {
+	action string
+	reply  chan *Response
+}
+
+func newConnection(t transport.Transport, logger func() *Logger, unsolicited func(*Response)) *connection {
+	conn := &connection{
+		transport:   t,
+		logger:      logger,
+		unsolicited: unsolicited,
+		pending:     make(map[int]*pendingCommand),
+		done:        make(chan struct{}),
+	}
+
+	go conn.read()
+	return conn
+}
+
+func (conn *connection) read() {
+	for {
+		rawResponse, err := conn.transport.Receive(context.Background())
+		if err != nil {
+			conn.fail(err)
+			return
+		}
+
+		conn.logger().Message("Received", rawResponse)
+
+		response, err := LoadResponse(string(rawResponse))
+		if err != nil {
+			conn.logger().Debugf("Dropping a message that can't be read: %s", err)
+			continue
+		}
+
+		conn.dispatch(response)
+	}
+}
+
+// dispatch hands response to the command it answers.
+func (conn *connection) dispatch(response *Response) {
+	conn.mu.Lock()
+	number := response.Number
+	if number == 0 && len(conn.order) > 0 && answers(conn.pending[conn.order[0]].action, response.Action) {
+		number = conn.order[0]
+	}
+
+	command, ok := conn.pending[number]
+	if ok {
+		conn.forget(number)
+	}
+	late := !ok && number != 0 && number <= conn.highest
+	conn.mu.Unlock()
+
+	switch {
+	case ok:
+		command.reply <- response
+	case late:
+		conn.logger().Debugf("Dropping the late reply %s to command %d", response.Action, number)
+	default:
+		conn.unsolicited(response)
+	}
+}
+
+// forget needs mu held.
+func (conn *connection) forget(number int) {
+	delete(conn.pending, number)
+	for i, n := range conn.order {
+		if n == number {
+			conn.order = append(conn.order[:i], conn.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// roundTrip sends msg, the command action numbered number, and waits for its
+// reply.
+func (conn *connection) roundTrip(ctx context.Context, action string, number int, msg []byte) (*Response, error) {
+	command := &pendingCommand{action: action, reply: make(chan *Response, 1)}
+
+	conn.mu.Lock()
+	if conn.err != nil {
+		err := conn.err
+		conn.mu.Unlock()
+		return nil, err
+	}
+	if _, ok := conn.pending[number]; ok {
+		conn.mu.Unlock()
+		return nil, fmt.Errorf("command %d is already waiting for a reply", number)
+	}
+	conn.pending[number] = command
+	conn.order = append(conn.order, number)
+	if number > conn.highest {
+		conn.highest = number
+	}
+	conn.mu.Unlock()
+
+	err := conn.send(ctx, msg)
+	if err != nil {
+		conn.mu.Lock()
+		conn.forget(number)
+		conn.mu.Unlock()
+		return nil, err
+	}
+
+	select {
+	case response := <-command.reply:
+		return response, nil
+	case <-conn.done:
+		// The reply may have arrived just before the connection failed
+		select {
+		case response := <-command.reply:
+			return response, nil
+		default:
+		}
+		return nil, conn.failure()
+	case <-ctx.Done():
+		conn.mu.Lock()
+		conn.forget(number)
+		conn.mu.Unlock()
+
+		// Unless the reply arrived meanwhile
+		select {
+		case response := <-command.reply:
+			return response, nil
+		default:
+		}
+		return nil, timeoutError(ctx.Err())
+	}
+}
+
+// send sends msg on its own. A failed send leaves the transport in an
+// unknown state, so it fails the connection unless ctx stopped it first.
+func (conn *connection) send(ctx context.Context, msg []byte) error {
+	conn.sendMu.Lock()
+	defer conn.sendMu.Unlock()
+
+	if err := conn.failure(); err != nil {
+		return err
+	}
+
+	conn.logger().Message("Sending", msg)
+	err := conn.transport.Send(ctx, msg)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return connectionError(err)
+	}
+
+	conn.fail(err)
+	return conn.failure()
+}
+
+// failure returns why the connection failed, nil while it works.
+func (conn *connection) failure() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	return conn.err
+}
+
+// fail marks the connection as failed by err, which fails every command
+// still waiting. Only the first error counts.
+func (conn *connection) fail(err error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.err != nil {
+		return
+	}
+	conn.err = connectionError(err)
+	close(conn.done)
+}
+
+func (conn *connection) close() error {
+	conn.fail(transport.ErrClosed)
+	return conn.transport.Close()
+}
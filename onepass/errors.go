@@ -0,0 +1,75 @@
+package onepass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by OnePasswordClient that callers may want to react to.
+// Errors carrying more detail wrap one of these, so test for them with
+// errors.Is.
+var (
+	ErrNotRegistered     = errors.New("sudolikeaboss is not registered with 1Password")
+	ErrAlreadyRegistered = errors.New("sudolikeaboss is already registered")
+	ErrHelperUnreachable = errors.New("could not reach the 1Password helper")
+	ErrAuthRejected      = errors.New("1Password rejected the authentication")
+	ErrUserCancelled     = errors.New("the 1Password popup was closed")
+	ErrTimeout           = errors.New("timed out waiting for 1Password")
+	ErrItemNotFound      = errors.New("item not found")
+	ErrClosed            = errors.New("the 1Password client is closed")
+	ErrConnectionLost    = errors.New("lost the connection to the 1Password helper")
+	ErrNoPassword        = &FieldNotFoundError{Name: "password"}
+
+	// ErrItemLookupUnsupported is returned when the helper doesn't answer
+	// the experimental getItem command.
+	ErrItemLookupUnsupported = errors.New("the 1Password helper does not support item lookup")
+)
+
+// FieldNotFoundError is returned when an item does not have the requested
+// field.
+type FieldNotFoundError struct {
+	Name string
+}
+
+func (err *FieldNotFoundError) Error() string {
+	return fmt.Sprintf("no %s found in the item", err.Name)
+}
+
+func noFieldError(name string) error {
+	if name == "password" {
+		return ErrNoPassword
+	}
+	return &FieldNotFoundError{Name: name}
+}
+
+// timeoutError reports a call cut short by the deadline of its context as
+// ErrTimeout.
+func timeoutError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s", ErrTimeout, err)
+	}
+	return err
+}
+
+// errNotConnected is returned when reconnecting failed earlier, so the next
+// command tries again.
+var errNotConnected = fmt.Errorf("%w: not connected", ErrConnectionLost)
+
+// connectionError tells a transport error that lost the connection apart
+// from a call cut short by its context.
+func connectionError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.DeadlineExceeded):
+		return timeoutError(err)
+	case errors.Is(err, context.Canceled):
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrConnectionLost, err)
+}
+
+func authRejected(action string) error {
+	return fmt.Errorf("%w: unexpected response %s", ErrAuthRejected, action)
+}
@@ -0,0 +1,24 @@
+package onepass
+
+import "time"
+
+// SetItemLookupTimeout changes how long SendGetItemCommand waits for a reply
+// and returns a function restoring it.
+func SetItemLookupTimeout(timeout time.Duration) func() {
+	previous := itemLookupTimeout
+	itemLookupTimeout = timeout
+	return func() { itemLookupTimeout = previous }
+}
+
+// SessionKeys exposes the derived session keys to the tests.
+func (client *OnePasswordClient) SessionKeys() (encK []byte, hmacK []byte) {
+	if client.session == nil {
+		return nil, nil
+	}
+	return client.session.encK, client.session.hmacK
+}
+
+// Secret exposes the pairing secret to the tests.
+func (client *OnePasswordClient) Secret() []byte {
+	return client.secret
+}
@@ -0,0 +1,496 @@
+// Package fakehelper is a local stand-in for the 1Password helper. It serves a
+// real websocket on localhost, native messaging over stdio or an in-memory
+// transport, and speaks the same auth-sma-hmac256 and aead-cbchmac-256
+// protocol as onepass.OnePasswordClient, so the client can be exercised end
+// to end without 1Password installed.
+package fakehelper
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	ws "golang.org/x/net/websocket"
+
+	"github.com/brycekahle/sudolikeaboss/nativemessaging"
+	"github.com/brycekahle/sudolikeaboss/onepass"
+	"github.com/brycekahle/sudolikeaboss/transport"
+)
+
+const (
+	helperVersion = "4.6.2.90"
+	authMethod    = "auth-sma-hmac256"
+	encAlgorithm  = "aead-cbchmac-256"
+)
+
+var base64urlWithoutPadding = b64.URLEncoding.WithPadding(b64.NoPadding)
+
+// Helper is a fake 1Password helper listening on a loopback address.
+type Helper struct {
+	Vault *Vault
+
+	// Popup picks the item the "user" clicks when a showPopup command arrives.
+	// Returning nil closes the popup without picking anything. The default
+	// picks the first item matching the requested URL, or the first item in
+	// the vault. Popup may block to simulate a user who never answers.
+	Popup func(url string, vault *Vault) *Item
+
+	// ApproveRegistration decides whether an authRegister request is
+	// accepted. The default accepts every registration.
+	ApproveRegistration func(extID string, code string) bool
+
+	// Unsupported lists actions that get no reply, like a helper that doesn't
+	// know them, for the commands that aren't in the documented protocol.
+	Unsupported []string
+
+	mu            sync.Mutex
+	registrations map[string][]byte
+	listener      net.Listener
+	server        *http.Server
+}
+
+// session is the per connection state of the handshake.
+type session struct {
+	extID string
+	code  string
+	m3    []byte
+	encK  []byte
+	hmacK []byte
+}
+
+type message struct {
+	Action  string      `json:"action"`
+	Number  int         `json:"number,omitempty"`
+	Version string      `json:"version,omitempty"`
+	Payload interface{} `json:"payload"`
+}
+
+func NewHelper(vault *Vault) *Helper {
+	if vault == nil {
+		vault = NewVault()
+	}
+
+	return &Helper{
+		Vault:         vault,
+		registrations: make(map[string][]byte),
+	}
+}
+
+// Start listens on a random loopback port and serves in the background.
+func (helper *Helper) Start() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	wsServer := ws.Server{
+		Handshake: func(*ws.Config, *http.Request) error { return nil },
+		Handler:   helper.serve,
+	}
+
+	helper.listener = listener
+	helper.server = &http.Server{Handler: wsServer}
+
+	go func() {
+		_ = helper.server.Serve(listener)
+	}()
+
+	return nil
+}
+
+// URL is the websocket URI clients should dial.
+func (helper *Helper) URL() string {
+	return fmt.Sprintf("ws://%s/4", helper.listener.Addr().String())
+}
+
+// Configuration returns a client configuration pointing at this helper.
+func (helper *Helper) Configuration(stateDirectory string) *onepass.Configuration {
+	return &onepass.Configuration{
+		WebsocketURI:    helper.URL(),
+		WebsocketOrigin: "resource://onepassword-at-agilebits-dot-com",
+		DefaultHost:     "sudolikeaboss://local",
+		StateDirectory:  stateDirectory,
+	}
+}
+
+func (helper *Helper) Close() error {
+	if helper.server == nil {
+		return nil
+	}
+	return helper.server.Close()
+}
+
+// Register pairs extID with secret as if the user had accepted it.
+func (helper *Helper) Register(extID string, secret []byte) {
+	helper.mu.Lock()
+	defer helper.mu.Unlock()
+
+	helper.registrations[extID] = secret
+}
+
+func (helper *Helper) IsRegistered(extID string) bool {
+	return helper.secretFor(extID) != nil
+}
+
+func (helper *Helper) secretFor(extID string) []byte {
+	helper.mu.Lock()
+	defer helper.mu.Unlock()
+
+	return helper.registrations[extID]
+}
+
+func (helper *Helper) serve(conn *ws.Conn) {
+	_ = helper.ServeTransport(context.Background(), transport.NewWebsocket(conn, ws.Message))
+}
+
+// Dial connects to the helper through an in-memory pipe, without a
+// websocket.
+func (helper *Helper) Dial(ctx context.Context) (transport.Transport, error) {
+	return transport.PipeDialer(func(t transport.Transport) {
+		_ = helper.ServeTransport(context.Background(), t)
+	})(ctx)
+}
+
+// ServeTransport answers the commands received on t until it is closed, and
+// closes it.
+func (helper *Helper) ServeTransport(ctx context.Context, t transport.Transport) error {
+	defer t.Close()
+
+	state := &session{}
+
+	for {
+		raw, err := t.Receive(ctx)
+		if err == transport.ErrClosed || err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		reply, err := helper.reply(state, raw)
+		if err != nil {
+			return err
+		}
+		if reply == nil {
+			continue
+		}
+
+		if err := t.Send(ctx, reply); err != nil {
+			return err
+		}
+	}
+}
+
+// ServeNative speaks the native messaging framing over r and w, the stdin
+// and stdout of a helper process, until r ends.
+func (helper *Helper) ServeNative(r io.Reader, w io.Writer) error {
+	state := &session{}
+
+	for {
+		raw, err := nativemessaging.ReadMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		reply, err := helper.reply(state, raw)
+		if err != nil {
+			return err
+		}
+		if reply == nil {
+			continue
+		}
+
+		if err := nativemessaging.WriteMessage(w, reply); err != nil {
+			return err
+		}
+	}
+}
+
+// reply handles one raw command and returns the raw reply, or nil if there
+// is none.
+func (helper *Helper) reply(state *session, raw []byte) ([]byte, error) {
+	var command onepass.Command
+	if err := json.Unmarshal(raw, &command); err != nil {
+		log.Printf("fakehelper: bad command: %s", err)
+		return nil, err
+	}
+
+	reply, err := helper.handle(state, &command)
+	if err != nil {
+		log.Printf("fakehelper: %s: %s", command.Action, err)
+		reply = &message{
+			Action:  "authFailed",
+			Payload: map[string]string{"error": err.Error()},
+		}
+	}
+
+	if reply == nil {
+		return nil, nil
+	}
+
+	reply.Number = command.Number
+	reply.Version = helperVersion
+	return json.Marshal(reply)
+}
+
+func (helper *Helper) handle(state *session, command *onepass.Command) (*message, error) {
+	for _, action := range helper.Unsupported {
+		if action == command.Action {
+			log.Printf("fakehelper: ignoring %s", command.Action)
+			return nil, nil
+		}
+	}
+
+	switch command.Action {
+	case "hello":
+		return helper.hello(state, &command.Payload)
+	case "authRegister":
+		return helper.authRegister(state, &command.Payload)
+	case "authBegin":
+		return helper.authBegin(state, &command.Payload)
+	case "authVerify":
+		return helper.authVerify(state, &command.Payload)
+	case "showPopup":
+		return helper.showPopup(state, &command.Payload)
+	case "getItem":
+		return helper.getItem(state, &command.Payload)
+	}
+
+	return nil, fmt.Errorf("unknown action %q", command.Action)
+}
+
+func (helper *Helper) hello(state *session, payload *onepass.Payload) (*message, error) {
+	state.extID = payload.ExtID
+
+	if helper.IsRegistered(payload.ExtID) {
+		return &message{Action: "authBegin", Payload: map[string]string{}}, nil
+	}
+
+	code, err := onepass.GenerateRandomBytes(3)
+	if err != nil {
+		return nil, err
+	}
+	state.code = strings.ToUpper(fmt.Sprintf("%x", code))
+
+	return &message{Action: "authNew", Payload: map[string]string{"code": state.code}}, nil
+}
+
+func (helper *Helper) authRegister(state *session, payload *onepass.Payload) (*message, error) {
+	if payload.Method != authMethod {
+		return nil, fmt.Errorf("unsupported method %q", payload.Method)
+	}
+
+	secret, err := base64urlWithoutPadding.DecodeString(strings.TrimRight(payload.Secret, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	if helper.ApproveRegistration != nil && !helper.ApproveRegistration(payload.ExtID, state.code) {
+		return nil, errors.New("registration rejected")
+	}
+
+	helper.Register(payload.ExtID, secret)
+
+	return &message{Action: "authRegistered", Payload: map[string]string{"method": authMethod}}, nil
+}
+
+func (helper *Helper) authBegin(state *session, payload *onepass.Payload) (*message, error) {
+	secret := helper.secretFor(payload.ExtID)
+	if secret == nil {
+		return nil, errors.New("unknown extension")
+	}
+	state.extID = payload.ExtID
+
+	cc, err := base64urlWithoutPadding.DecodeString(payload.CC)
+	if err != nil {
+		return nil, err
+	}
+
+	cs, err := onepass.GenerateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+
+	// M3 = HMAC-SHA256(secret, SHA256(cs|cc))
+	csAndCcSha := sha256.Sum256(append(cs[:len(cs):len(cs)], cc...))
+	state.m3 = onepass.HmacSha256(secret, csAndCcSha[:])
+
+	return &message{
+		Action: "authContinue",
+		Payload: onepass.Payload{
+			Method: authMethod,
+			M3:     base64urlWithoutPadding.EncodeToString(state.m3),
+			CS:     base64urlWithoutPadding.EncodeToString(cs),
+		},
+	}, nil
+}
+
+func (helper *Helper) authVerify(state *session, payload *onepass.Payload) (*message, error) {
+	secret := helper.secretFor(state.extID)
+	if secret == nil || state.m3 == nil {
+		return nil, errors.New("authVerify before authBegin")
+	}
+
+	m4, err := base64urlWithoutPadding.DecodeString(payload.M4)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(onepass.HmacSha256(secret, state.m3), m4) {
+		return nil, errors.New("M4 not expected value")
+	}
+
+	state.encK = onepass.HmacSha256(secret, state.m3, m4, []byte("encryption"))
+	state.hmacK = onepass.HmacSha256(secret, m4, state.m3, []byte("hmac"))
+
+	encrypted, err := state.encrypt(map[string]interface{}{
+		"version":      helperVersion,
+		"capabilities": []string{authMethod, encAlgorithm},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &message{Action: "welcome", Payload: encrypted}, nil
+}
+
+func (helper *Helper) showPopup(state *session, payload *onepass.Payload) (*message, error) {
+	var request onepass.Payload
+	if err := state.decrypt(payload, &request); err != nil {
+		return nil, err
+	}
+
+	popup := helper.Popup
+	if popup == nil {
+		popup = defaultPopup
+	}
+
+	item := popup(request.URL, helper.Vault)
+	if item == nil {
+		return &message{Action: "popupClosed", Payload: map[string]string{}}, nil
+	}
+
+	return state.fillItem(item)
+}
+
+func (helper *Helper) getItem(state *session, payload *onepass.Payload) (*message, error) {
+	var request onepass.Payload
+	if err := state.decrypt(payload, &request); err != nil {
+		return nil, err
+	}
+
+	item := helper.Vault.Find(request.UUID, request.Title)
+	if item == nil {
+		return &message{Action: "itemNotFound", Payload: map[string]string{}}, nil
+	}
+
+	return state.fillItem(item)
+}
+
+func (state *session) fillItem(item *Item) (*message, error) {
+	encrypted, err := state.encrypt(map[string]interface{}{
+		"action":        item.FillAction(),
+		"item":          item,
+		"openInTabMode": "NewTab",
+		"options": map[string]interface{}{
+			"animate":    true,
+			"autosubmit": true,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &message{Action: "fillItem", Payload: encrypted}, nil
+}
+
+func defaultPopup(url string, vault *Vault) *Item {
+	if matches := vault.Match(url); len(matches) > 0 {
+		return matches[0]
+	}
+
+	items := vault.Items()
+	if len(items) == 0 {
+		return nil
+	}
+	return items[0]
+}
+
+func (state *session) encrypt(v interface{}) (*onepass.Payload, error) {
+	if state.encK == nil {
+		return nil, errors.New("session not authenticated")
+	}
+
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	iv, err := onepass.GenerateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := onepass.Encrypt(state.encK, iv, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	ivB64 := base64urlWithoutPadding.EncodeToString(iv)
+	dataB64 := base64urlWithoutPadding.EncodeToString(ciphertext)
+	hmacB64 := base64urlWithoutPadding.EncodeToString(onepass.HmacSha256(state.hmacK, []byte(ivB64), []byte(dataB64)))
+
+	return &onepass.Payload{
+		Algorithm: encAlgorithm,
+		Iv:        ivB64,
+		Data:      dataB64,
+		Hmac:      hmacB64,
+	}, nil
+}
+
+func (state *session) decrypt(payload *onepass.Payload, v interface{}) error {
+	if state.encK == nil {
+		return errors.New("session not authenticated")
+	}
+
+	expectedHmac := onepass.HmacSha256(state.hmacK, []byte(payload.Iv), []byte(payload.Data))
+
+	hmac, err := base64urlWithoutPadding.DecodeString(payload.Hmac)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(expectedHmac, hmac) {
+		return errors.New("Hmac unexpected")
+	}
+
+	iv, err := base64urlWithoutPadding.DecodeString(payload.Iv)
+	if err != nil {
+		return err
+	}
+
+	data, err := base64urlWithoutPadding.DecodeString(payload.Data)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := onepass.Decrypt(state.encK, iv, data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(plaintext, v)
+}
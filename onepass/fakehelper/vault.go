@@ -0,0 +1,166 @@
+package fakehelper
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Item is a single entry in the fake vault. Items with a Username or Fields
+// are served as login items, all others as password items.
+type Item struct {
+	UUID     string
+	Title    string
+	URL      string
+	Username string
+	Password string
+	Notes    string
+	// TOTP is an otpauth:// URI or base32 secret stored as a one-time
+	// password field.
+	TOTP     string
+	Fields   []map[string]string
+	HTMLForm map[string]interface{}
+}
+
+// IsLogin reports whether the item is sent to clients as a fillLogin item.
+func (item *Item) IsLogin() bool {
+	return item.Username != "" || len(item.Fields) > 0
+}
+
+// FillAction returns the payload action 1Password uses for this kind of item.
+func (item *Item) FillAction() string {
+	if item.IsLogin() {
+		return "fillLogin"
+	}
+	return "fillPassword"
+}
+
+// MarshalJSON renders the item in the shape the 1Password helper sends it,
+// which is what onepass.LoginItem and onepass.PasswordItem parse.
+func (item *Item) MarshalJSON() ([]byte, error) {
+	overview := map[string]interface{}{
+		"title": item.Title,
+		"url":   item.URL,
+	}
+
+	if !item.IsLogin() {
+		return json.Marshal(map[string]interface{}{
+			"uuid":     item.UUID,
+			"overview": overview,
+			"secureContents": map[string]interface{}{
+				"password":   item.Password,
+				"notesPlain": item.Notes,
+				"sections":   item.sections(),
+			},
+		})
+	}
+
+	fields := item.Fields
+	if fields == nil {
+		fields = []map[string]string{
+			{
+				"value":       item.Username,
+				"id":          "username",
+				"name":        "username",
+				"type":        "T",
+				"designation": "username",
+			},
+			{
+				"value":       item.Password,
+				"id":          "password",
+				"name":        "password",
+				"type":        "P",
+				"designation": "password",
+			},
+		}
+	}
+
+	htmlForm := item.HTMLForm
+	if htmlForm == nil {
+		htmlForm = map[string]interface{}{"htmlMethod": "post"}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"uuid":         item.UUID,
+		"nakedDomains": []string{},
+		"overview":     overview,
+		"secureContents": map[string]interface{}{
+			"htmlForm":   htmlForm,
+			"fields":     fields,
+			"notesPlain": item.Notes,
+			"sections":   item.sections(),
+		},
+	})
+}
+
+func (item *Item) sections() []map[string]interface{} {
+	if item.TOTP == "" {
+		return []map[string]interface{}{}
+	}
+
+	return []map[string]interface{}{
+		{
+			"name":  "linked items",
+			"title": "Related Items",
+			"fields": []map[string]string{
+				{
+					"k": "concealed",
+					"n": "TOTP_1",
+					"t": "one-time password",
+					"v": item.TOTP,
+				},
+			},
+		},
+	}
+}
+
+// Vault is an in-memory, concurrency safe set of items.
+type Vault struct {
+	mu    sync.Mutex
+	items []*Item
+}
+
+func NewVault(items ...*Item) *Vault {
+	return &Vault{items: items}
+}
+
+func (vault *Vault) Add(item *Item) {
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+
+	vault.items = append(vault.items, item)
+}
+
+// Items returns a snapshot of every item in the vault.
+func (vault *Vault) Items() []*Item {
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+
+	items := make([]*Item, len(vault.items))
+	copy(items, vault.items)
+	return items
+}
+
+// Match returns the items whose URL equals url.
+func (vault *Vault) Match(url string) []*Item {
+	var matches []*Item
+	for _, item := range vault.Items() {
+		if item.URL == url {
+			matches = append(matches, item)
+		}
+	}
+	return matches
+}
+
+// Find returns the item with the given uuid or, when uuid is empty, the first
+// item with the given title.
+func (vault *Vault) Find(uuid string, title string) *Item {
+	for _, item := range vault.Items() {
+		if uuid != "" && item.UUID == uuid {
+			return item
+		}
+		if uuid == "" && title != "" && item.Title == title {
+			return item
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package onepass
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// ErrKeyringItemNotFound is returned by a Keyring without the item.
+var ErrKeyringItemNotFound = errors.New("secret not found in the keyring")
+
+// Keyring is the small part of an OS credential store sudolikeaboss needs.
+type Keyring interface {
+	Get(service string, account string) (string, error)
+	Set(service string, account string, secret string) error
+	Delete(service string, account string) error
+}
+
+// CommandRunner runs name with args, feeding it stdin, and returns its
+// stdout. Errors of commands that ran wrap their *exec.ExitError.
+type CommandRunner func(stdin string, name string, args ...string) (string, error)
+
+// RunCommand is the CommandRunner that runs real commands.
+func RunCommand(stdin string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// NewSystemKeyring returns the macOS keychain on darwin and the Secret
+// Service (through secret-tool) everywhere else.
+func NewSystemKeyring() Keyring {
+	if runtime.GOOS == "darwin" {
+		return &KeychainKeyring{Run: RunCommand}
+	}
+	return &SecretServiceKeyring{Run: RunCommand}
+}
+
+// KeychainKeyring stores secrets in the macOS keychain with security(1).
+// Secrets are passed on stdin so they never show up in the process list.
+type KeychainKeyring struct {
+	Run CommandRunner
+}
+
+// keychainItemNotFound is the exit status of security(1) for a missing item.
+const keychainItemNotFound = 44
+
+func (keyring *KeychainKeyring) Get(service string, account string) (string, error) {
+	out, err := keyring.Run("", "security", "find-generic-password", "-s", service, "-a", account, "-w")
+	if exitStatus(err) == keychainItemNotFound {
+		return "", fmt.Errorf("%w: %s", ErrKeyringItemNotFound, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading the keychain: %w", err)
+	}
+	return strings.TrimSuffix(out, "\n"), nil
+}
+
+func (keyring *KeychainKeyring) Set(service string, account string, secret string) error {
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", quoteKeychainArg(service), quoteKeychainArg(account), quoteKeychainArg(secret))
+	_, err := keyring.Run(command, "security", "-i")
+	return err
+}
+
+// Delete succeeds when there is no such item, there is nothing to delete.
+func (keyring *KeychainKeyring) Delete(service string, account string) error {
+	_, err := keyring.Run("", "security", "delete-generic-password", "-s", service, "-a", account)
+	if exitStatus(err) == keychainItemNotFound {
+		return nil
+	}
+	return err
+}
+
+// exitStatus returns the exit status of the command that failed with err, or
+// -1 if err isn't from a command that exited.
+func exitStatus(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// quoteKeychainArg quotes s for the interactive mode of security(1).
+func quoteKeychainArg(s string) string {
+	return `"` + strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
+
+// SecretServiceKeyring stores secrets in the freedesktop Secret Service
+// (GNOME Keyring, KWallet) with secret-tool(1).
+type SecretServiceKeyring struct {
+	Run CommandRunner
+}
+
+func (keyring *SecretServiceKeyring) Get(service string, account string) (string, error) {
+	out, err := keyring.Run("", "secret-tool", "lookup", "service", service, "account", account)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrKeyringItemNotFound, err)
+	}
+	if out == "" {
+		return "", ErrKeyringItemNotFound
+	}
+	return strings.TrimSuffix(out, "\n"), nil
+}
+
+func (keyring *SecretServiceKeyring) Set(service string, account string, secret string) error {
+	_, err := keyring.Run(secret, "secret-tool", "store", "--label="+service+" pairing secret", "service", service, "account", account)
+	return err
+}
+
+func (keyring *SecretServiceKeyring) Delete(service string, account string) error {
+	_, err := keyring.Run("", "secret-tool", "clear", "service", service, "account", account)
+	return err
+}
+
+// MemoryKeyring is a Keyring that only lives as long as the process, for
+// tests and short lived containers.
+type MemoryKeyring struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+func NewMemoryKeyring() *MemoryKeyring {
+	return &MemoryKeyring{secrets: make(map[string]string)}
+}
+
+func (keyring *MemoryKeyring) Get(service string, account string) (string, error) {
+	keyring.mu.Lock()
+	defer keyring.mu.Unlock()
+
+	secret, ok := keyring.secrets[service+"\x00"+account]
+	if !ok {
+		return "", ErrKeyringItemNotFound
+	}
+	return secret, nil
+}
+
+func (keyring *MemoryKeyring) Set(service string, account string, secret string) error {
+	keyring.mu.Lock()
+	defer keyring.mu.Unlock()
+
+	keyring.secrets[service+"\x00"+account] = secret
+	return nil
+}
+
+func (keyring *MemoryKeyring) Delete(service string, account string) error {
+	keyring.mu.Lock()
+	defer keyring.mu.Unlock()
+
+	delete(keyring.secrets, service+"\x00"+account)
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package onepass
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// lockFile isn't implemented without flock or LockFileEx, so creating the
+// state fails there rather than racing other processes.
+func lockFile(file *os.File) error {
+	return fmt.Errorf("file locking is not supported on %s", runtime.GOOS)
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,132 @@
+package onepass
+
+import (
+	b64 "encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Sensitivity classifies a value before it is logged.
+type Sensitivity int
+
+const (
+	// Public values are logged as is.
+	Public Sensitivity = iota
+	// PairingSecret is the registration secret from the state file.
+	PairingSecret
+	// SessionKey covers the derived encK and hmacK.
+	SessionKey
+	// AuthProof covers the handshake values cc, cs, M3 and M4.
+	AuthProof
+	// Ciphertext covers encrypted payloads and their iv and hmac.
+	Ciphertext
+	// ItemContents covers decrypted payloads and the items in them.
+	ItemContents
+)
+
+func (sensitivity Sensitivity) String() string {
+	switch sensitivity {
+	case Public:
+		return "public"
+	case PairingSecret:
+		return "pairing-secret"
+	case SessionKey:
+		return "session-key"
+	case AuthProof:
+		return "auth-proof"
+	case Ciphertext:
+		return "ciphertext"
+	case ItemContents:
+		return "item-contents"
+	}
+	return "unknown"
+}
+
+// payloadKeySensitivity classifies the keys of protocol messages. Keys that
+// aren't listed are public.
+var payloadKeySensitivity = map[string]Sensitivity{
+	"secret": PairingSecret,
+	"cc":     AuthProof,
+	"cs":     AuthProof,
+	"M3":     AuthProof,
+	"m3":     AuthProof,
+	"M4":     AuthProof,
+	"m4":     AuthProof,
+	"iv":     Ciphertext,
+	"data":   Ciphertext,
+	"hmac":   Ciphertext,
+	"item":   ItemContents,
+}
+
+// Logger logs protocol events through logrus, masking every value that
+// isn't Public. Setting Unsafe logs everything in the clear, which is only
+// meant for debugging the protocol itself.
+type Logger struct {
+	Log    log.FieldLogger
+	Unsafe bool
+}
+
+func NewLogger(logger log.FieldLogger) *Logger {
+	return &Logger{Log: logger}
+}
+
+// Mask returns value, or a placeholder naming its class when it is
+// sensitive and logging isn't unsafe.
+func (logger *Logger) Mask(sensitivity Sensitivity, value string) string {
+	if logger.Unsafe || sensitivity == Public {
+		return value
+	}
+	return fmt.Sprintf("[REDACTED %s]", sensitivity)
+}
+
+// MaskBytes is Mask for binary values, which are base64 encoded when shown.
+func (logger *Logger) MaskBytes(sensitivity Sensitivity, value []byte) string {
+	return logger.Mask(sensitivity, b64.URLEncoding.WithPadding(b64.NoPadding).EncodeToString(value))
+}
+
+// Message logs a raw protocol message with its sensitive payload values
+// masked. Messages that aren't valid JSON are masked as a whole.
+func (logger *Logger) Message(direction string, message []byte) {
+	logger.Log.Debugf("%s: %s", direction, logger.maskJSON(message))
+}
+
+func (logger *Logger) Debugf(format string, args ...interface{}) {
+	logger.Log.Debugf(format, args...)
+}
+
+func (logger *Logger) maskJSON(message []byte) string {
+	if logger.Unsafe {
+		return string(message)
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(message, &v); err != nil {
+		return logger.Mask(ItemContents, string(message))
+	}
+
+	masked, err := json.Marshal(logger.maskValue(v))
+	if err != nil {
+		return logger.Mask(ItemContents, string(message))
+	}
+	return string(masked)
+}
+
+func (logger *Logger) maskValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if sensitivity, ok := payloadKeySensitivity[key]; ok {
+				value[key] = logger.Mask(sensitivity, fmt.Sprint(child))
+			} else {
+				value[key] = logger.maskValue(child)
+			}
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = logger.maskValue(child)
+		}
+	}
+	return v
+}
@@ -0,0 +1,78 @@
+package onepass_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+
+	"github.com/brycekahle/sudolikeaboss/nativemessaging"
+	. "github.com/brycekahle/sudolikeaboss/onepass"
+	"github.com/brycekahle/sudolikeaboss/onepass/fakehelper"
+	"github.com/brycekahle/sudolikeaboss/transport"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// nativeHelperEnvironmentVariable makes the test binary act as a native
+// messaging helper, so it can be spawned like the real one.
+const nativeHelperEnvironmentVariable = "SUDOLIKEABOSS_TEST_NATIVE_HELPER"
+
+func init() {
+	if os.Getenv(nativeHelperEnvironmentVariable) == "" {
+		return
+	}
+
+	helper := fakehelper.NewHelper(fakehelper.NewVault(&fakehelper.Item{
+		Title:    "native",
+		URL:      "sudolikeaboss://local",
+		Password: "nativepassword",
+	}))
+
+	err := helper.ServeNative(os.Stdin, os.Stdout)
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+var _ = Describe("Native messaging", func() {
+	It("should frame messages with a little-endian length", func() {
+		var buf bytes.Buffer
+		Expect(nativemessaging.WriteMessage(&buf, []byte(`{"action":"hello"}`))).To(Succeed())
+		Expect(buf.Bytes()[:4]).To(Equal([]byte{18, 0, 0, 0}))
+
+		message, err := nativemessaging.ReadMessage(&buf)
+		Expect(err).To(BeNil())
+		Expect(string(message)).To(Equal(`{"action":"hello"}`))
+	})
+
+	It("should refuse oversized messages", func() {
+		_, err := nativemessaging.ReadMessage(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+		Expect(err).To(MatchError(ContainSubstring("too large")))
+	})
+
+	It("should register and show the popup through a spawned helper", func() {
+		stateDir, err := ioutil.TempDir("", "sudolikeaboss")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(stateDir)
+
+		os.Setenv(nativeHelperEnvironmentVariable, "1")
+		defer os.Unsetenv(nativeHelperEnvironmentVariable)
+
+		client, err := NewClientWithConfig(&Configuration{
+			DefaultHost:    "sudolikeaboss://local",
+			StateDirectory: stateDir,
+			Dialer:         transport.DialNativeMessaging(os.Args[0]),
+		})
+		Expect(err).To(BeNil())
+		defer client.Close()
+
+		_, err = client.Authenticate(context.Background(), true)
+		Expect(err).To(BeNil())
+
+		response, err := client.SendShowPopupCommand(context.Background())
+		Expect(err).To(BeNil())
+		Expect(response.GetPassword()).To(Equal("nativepassword"))
+	})
+})
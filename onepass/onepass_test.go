@@ -1,11 +1,25 @@
 package onepass_test
 
 import (
+	"bytes"
+	"context"
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path"
+	"sync"
+	"time"
 
 	. "github.com/brycekahle/sudolikeaboss/onepass"
+	"github.com/brycekahle/sudolikeaboss/onepass/fakehelper"
+	"github.com/brycekahle/sudolikeaboss/transport"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
 )
 
 const SAMPLE_RESPONSE_0 = `
@@ -56,31 +70,95 @@ const SAMPLE_RESPONSE_0 = `
 }
 `
 
+// MockWebsocketClient replies with responseString to every message sent. Like
+// a real connection, Receive blocks until there is a reply or it is closed.
 type MockWebsocketClient struct {
 	responseString string
+
+	mu      sync.Mutex
+	replies chan string
+	closed  chan struct{}
 }
 
 func (mock *MockWebsocketClient) Connect() error {
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+
+	mock.replies = make(chan string, 16)
+	mock.closed = make(chan struct{})
 	return nil
 }
 
 func (mock *MockWebsocketClient) Receive(v interface{}) error {
+	mock.mu.Lock()
+	replies, closed := mock.replies, mock.closed
+	mock.mu.Unlock()
+
+	var reply string
+	select {
+	case reply = <-replies:
+	case <-closed:
+		return io.EOF
+	}
+
 	switch data := v.(type) {
 	case *string:
-		*data = mock.responseString
+		*data = reply
 		return nil
 	case *[]byte:
-		*data = []byte(mock.responseString)
+		*data = []byte(reply)
 		return nil
 	}
 	return nil
 }
 
 func (mock *MockWebsocketClient) Send(v interface{}) error {
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+
+	if mock.responseString != "" {
+		mock.replies <- mock.responseString
+	}
+	return nil
+}
+
+func (mock *MockWebsocketClient) Close() error {
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+
+	select {
+	case <-mock.closed:
+	default:
+		close(mock.closed)
+	}
 	return nil
 }
 
 var _ = Describe("Termpass", func() {
+	var (
+		mockClients   []*OnePasswordClient
+		mockClientsMu sync.Mutex
+	)
+
+	// newMockClient creates a client on a MockWebsocketClient that is closed
+	// after the test.
+	newMockClient := func(stateDirectory string, options ...ClientOption) (*OnePasswordClient, error) {
+		client, err := NewCustomClient(&MockWebsocketClient{}, "fakehost", stateDirectory, options...)
+		if err == nil {
+			mockClientsMu.Lock()
+			mockClients = append(mockClients, client)
+			mockClientsMu.Unlock()
+		}
+		return client, err
+	}
+
+	AfterEach(func() {
+		for _, client := range mockClients {
+			Expect(client.Close()).To(Succeed())
+		}
+		mockClients = nil
+	})
+
 	Describe("Response", func() {
 		var (
 			response *Response
@@ -99,6 +177,391 @@ var _ = Describe("Termpass", func() {
 			Expect(password).To(Equal("password"))
 			Expect(err).To(BeNil())
 		})
+
+		It("should get the username", func() {
+			Expect(response.GetField("username")).To(Equal("username"))
+		})
+
+		It("should get a field by id or name", func() {
+			Expect(response.GetField("email")).To(Equal("username"))
+		})
+
+		It("should get an html form field", func() {
+			Expect(response.GetField("htmlForm.htmlMethod")).To(Equal("post"))
+		})
+
+		It("should fail on a missing field", func() {
+			_, err := response.GetField("missing")
+			Expect(err).To(Equal(&FieldNotFoundError{Name: "missing"}))
+		})
+
+		It("should fail when the item has no notes", func() {
+			_, err := response.GetField("notes")
+			Expect(err).To(Equal(&FieldNotFoundError{Name: "notes"}))
+		})
+
+		It("should fail when a password item has no password", func() {
+			_, err := PasswordItem{}.GetPassword()
+			Expect(err).To(Equal(ErrNoPassword))
+		})
+	})
+
+	Describe("Logger", func() {
+		var (
+			buf    *bytes.Buffer
+			logger *Logger
+		)
+
+		BeforeEach(func() {
+			buf = &bytes.Buffer{}
+			logrusLogger := logrus.New()
+			logrusLogger.Out = buf
+			logrusLogger.Level = logrus.DebugLevel
+			logger = NewLogger(logrusLogger)
+		})
+
+		It("should mask sensitive values by class", func() {
+			Expect(logger.Mask(Public, "hello")).To(Equal("hello"))
+			Expect(logger.Mask(SessionKey, "key")).To(Equal("[REDACTED session-key]"))
+			Expect(logger.MaskBytes(PairingSecret, []byte("secret"))).To(Equal("[REDACTED pairing-secret]"))
+		})
+
+		It("should mask sensitive payload keys of messages", func() {
+			logger.Message("Sending", []byte(`{"action":"authRegister","payload":{"extId":"ext","secret":"s3cr3t","M4":"m4value"}}`))
+
+			Expect(buf.String()).To(ContainSubstring("ext"))
+			Expect(buf.String()).ToNot(ContainSubstring("s3cr3t"))
+			Expect(buf.String()).ToNot(ContainSubstring("m4value"))
+			Expect(buf.String()).To(ContainSubstring("REDACTED auth-proof"))
+		})
+
+		It("should mask messages that are not JSON", func() {
+			logger.Message("Received", []byte("s3cr3t"))
+			Expect(buf.String()).ToNot(ContainSubstring("s3cr3t"))
+		})
+
+		It("should log everything when unsafe", func() {
+			logger.Unsafe = true
+			logger.Message("Sending", []byte(`{"payload":{"secret":"s3cr3t"}}`))
+			Expect(logger.Mask(SessionKey, "key")).To(Equal("key"))
+			Expect(buf.String()).To(ContainSubstring("s3cr3t"))
+		})
+	})
+
+	Describe("StateStore", func() {
+		It("should keep the state in memory", func() {
+			store := NewMemoryStateStore()
+
+			client, err := newMockClient("/nonexistent", WithStateStore(store))
+			Expect(err).To(BeNil())
+
+			state, err := store.Load()
+			Expect(err).To(BeNil())
+			Expect(state.Storage).To(Equal(PlaintextStorage))
+
+			reopened, err := newMockClient("/nonexistent", WithStateStore(store))
+			Expect(err).To(BeNil())
+			Expect(reopened.Secret()).To(Equal(client.Secret()))
+
+			Expect(store.Delete()).To(Succeed())
+			Expect(store.Load()).To(BeNil())
+		})
+
+		It("should read the state from the environment", func() {
+			memory := NewMemoryStateStore()
+			client, err := newMockClient("/nonexistent", WithStateStore(memory))
+			Expect(err).To(BeNil())
+
+			state, err := memory.Load()
+			Expect(err).To(BeNil())
+			stateJSON, err := json.Marshal(state)
+			Expect(err).To(BeNil())
+
+			os.Setenv("SUDOLIKEABOSS_TEST_STATE", string(stateJSON))
+			defer os.Unsetenv("SUDOLIKEABOSS_TEST_STATE")
+
+			fromEnv, err := newMockClient("/nonexistent", WithStateStore(NewEnvStateStore("SUDOLIKEABOSS_TEST_STATE")))
+			Expect(err).To(BeNil())
+			Expect(fromEnv.Secret()).To(Equal(client.Secret()))
+		})
+
+		It("should not save to the environment", func() {
+			_, err := newMockClient("/nonexistent", WithStateStore(NewEnvStateStore("SUDOLIKEABOSS_TEST_STATE")))
+			Expect(errors.Is(err, ErrReadOnlyStateStore)).To(BeTrue())
+		})
+
+		It("should delete the state file", func() {
+			stateDir, err := ioutil.TempDir("", "sudolikeaboss")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(stateDir)
+
+			store := NewFileStateStore(path.Join(stateDir, "nested"))
+			Expect(store.Save(&StateFileConfig{ExtID: "ext", Secret: "secret"})).To(Succeed())
+			Expect(store.Load()).To(Equal(&StateFileConfig{ExtID: "ext", Secret: "secret"}))
+
+			Expect(store.Delete()).To(Succeed())
+			Expect(store.Load()).To(BeNil())
+			Expect(store.Delete()).To(Succeed())
+		})
+
+		It("should create the state once when clients start concurrently", func() {
+			stateDir, err := ioutil.TempDir("", "sudolikeaboss")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(stateDir)
+
+			secrets := make(chan []byte, 10)
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					client, err := newMockClient(stateDir)
+					Expect(err).To(BeNil())
+					secrets <- client.Secret()
+				}()
+			}
+			wg.Wait()
+			close(secrets)
+
+			state, err := NewFileStateStore(stateDir).Load()
+			Expect(err).To(BeNil())
+			for secret := range secrets {
+				Expect(b64.RawURLEncoding.EncodeToString(secret)).To(Equal(state.Secret))
+			}
+		})
+
+		It("should write the state file atomically and only readable by the user", func() {
+			stateDir, err := ioutil.TempDir("", "sudolikeaboss")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(stateDir)
+
+			store := NewFileStateStore(stateDir)
+			Expect(store.Save(&StateFileConfig{ExtID: "ext", Secret: "secret"})).To(Succeed())
+			Expect(store.Save(&StateFileConfig{ExtID: "ext", Secret: "other"})).To(Succeed())
+
+			info, err := os.Stat(store.Path)
+			Expect(err).To(BeNil())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+			files, err := ioutil.ReadDir(stateDir)
+			Expect(err).To(BeNil())
+			var names []string
+			for _, file := range files {
+				names = append(names, file.Name())
+			}
+			Expect(names).To(ConsistOf("state.json"))
+		})
+
+		It("should not lose profiles saved concurrently", func() {
+			stateDir, err := ioutil.TempDir("", "sudolikeaboss")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(stateDir)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(name string) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					_, err := newMockClient(stateDir, WithStateStore(NewProfileStateStore(stateDir, name)))
+					Expect(err).To(BeNil())
+				}(fmt.Sprintf("profile%d", i))
+			}
+			wg.Wait()
+
+			profiles, err := LoadProfiles(path.Join(stateDir, ProfilesFileName))
+			Expect(err).To(BeNil())
+			Expect(profiles.Names()).To(HaveLen(10))
+		})
+
+		It("should keep each profile's state apart in the profiles file", func() {
+			stateDir, err := ioutil.TempDir("", "sudolikeaboss")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(stateDir)
+
+			work := NewProfileStateStore(stateDir, "work")
+			personal := NewProfileStateStore(stateDir, "personal")
+			Expect(work.Load()).To(BeNil())
+
+			Expect(work.Save(&StateFileConfig{ExtID: "work", Secret: "secret"})).To(Succeed())
+			Expect(personal.Save(&StateFileConfig{ExtID: "personal", Secret: "secret"})).To(Succeed())
+			Expect(work.Load()).To(Equal(&StateFileConfig{ExtID: "work", Secret: "secret"}))
+			Expect(personal.Load()).To(Equal(&StateFileConfig{ExtID: "personal", Secret: "secret"}))
+
+			Expect(work.Delete()).To(Succeed())
+			Expect(work.Load()).To(BeNil())
+
+			profiles, err := LoadProfiles(path.Join(stateDir, ProfilesFileName))
+			Expect(err).To(BeNil())
+			Expect(profiles.Names()).To(Equal([]string{"personal", "work"}))
+		})
+	})
+
+	Describe("SecretStore", func() {
+		var (
+			stateDir      string
+			stateFilePath string
+			err           error
+		)
+
+		passphrase := func(value string) func() ([]byte, error) {
+			return func() ([]byte, error) { return []byte(value), nil }
+		}
+
+		newClient := func(store SecretStore) (*OnePasswordClient, error) {
+			return newMockClient(stateDir, WithSecretStore(store))
+		}
+
+		readState := func() StateFileConfig {
+			var state StateFileConfig
+			stateFileStr, err := ioutil.ReadFile(stateFilePath)
+			Expect(err).To(BeNil())
+			Expect(json.Unmarshal(stateFileStr, &state)).To(Succeed())
+			return state
+		}
+
+		BeforeEach(func() {
+			stateDir, err = ioutil.TempDir("", "sudolikeaboss")
+			Expect(err).To(BeNil())
+			stateFilePath = path.Join(stateDir, "state.json")
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(stateDir)).To(Succeed())
+		})
+
+		It("should seal and open with a passphrase", func() {
+			store := &PassphraseSecretStore{Passphrase: passphrase("correct horse")}
+
+			sealed, err := store.Seal("ext", []byte("secret"))
+			Expect(err).To(BeNil())
+			Expect(sealed).ToNot(ContainSubstring(b64.RawURLEncoding.EncodeToString([]byte("secret"))))
+
+			Expect(store.Open("ext", sealed)).To(Equal([]byte("secret")))
+
+			_, err = store.Open("other ext", sealed)
+			Expect(err).To(Equal(ErrWrongPassphrase))
+
+			wrong := &PassphraseSecretStore{Passphrase: passphrase("battery staple")}
+			_, err = wrong.Open("ext", sealed)
+			Expect(err).To(Equal(ErrWrongPassphrase))
+		})
+
+		It("should migrate a plaintext state file", func() {
+			plaintext, err := newMockClient(stateDir)
+			Expect(err).To(BeNil())
+
+			state := readState()
+			Expect(state.Storage).To(Equal(PlaintextStorage))
+			plaintextSecret := state.Secret
+
+			client, err := newClient(&PassphraseSecretStore{Passphrase: passphrase("correct horse")})
+			Expect(err).To(BeNil())
+			Expect(client.Secret()).To(Equal(plaintext.Secret()))
+
+			state = readState()
+			Expect(state.Storage).To(Equal(PassphraseStorage))
+			Expect(state.Secret).ToNot(ContainSubstring(plaintextSecret))
+
+			reopened, err := newClient(&PassphraseSecretStore{Passphrase: passphrase("correct horse")})
+			Expect(err).To(BeNil())
+			Expect(reopened.Secret()).To(Equal(plaintext.Secret()))
+
+			_, err = newClient(&PassphraseSecretStore{Passphrase: passphrase("battery staple")})
+			Expect(err).To(Equal(ErrWrongPassphrase))
+
+			_, err = newClient(PlaintextSecretStore{})
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("should keep the secret in the keyring", func() {
+			keyring := NewMemoryKeyring()
+
+			client, err := newClient(&KeyringSecretStore{Keyring: keyring})
+			Expect(err).To(BeNil())
+
+			state := readState()
+			Expect(state.Storage).To(Equal(KeyringStorage))
+			Expect(keyring.Get(KeyringService, state.ExtID)).To(Equal(b64.RawURLEncoding.EncodeToString(client.Secret())))
+
+			reopened, err := newClient(&KeyringSecretStore{Keyring: keyring})
+			Expect(err).To(BeNil())
+			Expect(reopened.Secret()).To(Equal(client.Secret()))
+
+			_, err = newClient(&KeyringSecretStore{Keyring: NewMemoryKeyring()})
+			Expect(errors.Is(err, ErrKeyringItemNotFound)).To(BeTrue())
+		})
+
+		It("should delete the keyring secret when the state is forgotten", func() {
+			keyring := NewMemoryKeyring()
+			store := &KeyringSecretStore{Keyring: keyring}
+
+			_, err := newClient(store)
+			Expect(err).To(BeNil())
+			state := readState()
+
+			Expect(ForgetState(NewFileStateStore(stateDir), store)).To(Succeed())
+			_, err = keyring.Get(KeyringService, state.ExtID)
+			Expect(err).To(Equal(ErrKeyringItemNotFound))
+			Expect(Exists(stateFilePath)).To(BeFalse())
+		})
+
+		It("should only report a missing keychain item as not found", func() {
+			status := 44
+			run := func(stdin string, name string, args ...string) (string, error) {
+				return RunCommand("", "sh", "-c", fmt.Sprintf("exit %d", status))
+			}
+			keychain := &KeychainKeyring{Run: run}
+
+			_, err := keychain.Get("service", "account")
+			Expect(errors.Is(err, ErrKeyringItemNotFound)).To(BeTrue())
+			Expect(keychain.Delete("service", "account")).To(Succeed())
+
+			status = 51
+			_, err = keychain.Get("service", "account")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrKeyringItemNotFound)).To(BeFalse())
+			Expect(keychain.Delete("service", "account")).NotTo(Succeed())
+		})
+
+		It("should never pass secrets to keyring tools as arguments", func() {
+			var calls [][]string
+			var stdins []string
+			run := func(stdin string, name string, args ...string) (string, error) {
+				calls = append(calls, append([]string{name}, args...))
+				stdins = append(stdins, stdin)
+				return "", nil
+			}
+
+			Expect((&KeychainKeyring{Run: run}).Set("service", "account", "s3cr3t")).To(Succeed())
+			Expect((&SecretServiceKeyring{Run: run}).Set("service", "account", "s3cr3t")).To(Succeed())
+
+			for i, call := range calls {
+				Expect(call).ToNot(ContainElement(ContainSubstring("s3cr3t")))
+				Expect(stdins[i]).To(ContainSubstring("s3cr3t"))
+			}
+		})
+	})
+
+	Describe("TOTP", func() {
+		// Test vectors from RFC 6238 appendix B
+		It("should generate SHA1 codes", func() {
+			uri := "otpauth://totp/test?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8"
+			Expect(TOTP(uri, time.Unix(59, 0))).To(Equal("94287082"))
+			Expect(TOTP(uri, time.Unix(1111111109, 0))).To(Equal("07081804"))
+		})
+
+		It("should generate SHA256 codes", func() {
+			uri := "otpauth://totp/test?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZA&digits=8&algorithm=SHA256"
+			Expect(TOTP(uri, time.Unix(59, 0))).To(Equal("46119246"))
+		})
+
+		It("should accept a bare secret", func() {
+			Expect(TOTP("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", time.Unix(59, 0))).To(Equal("287082"))
+		})
 	})
 
 	Describe("Client", func() {
@@ -114,33 +577,542 @@ var _ = Describe("Termpass", func() {
 			Expect(err).To(BeNil())
 		})
 
+		AfterEach(func() {
+			Expect(client.Close()).To(Succeed())
+		})
+
 		It("should connect", func() {
-			err := client.Connect()
+			err := client.Connect(context.Background())
 			Expect(err).To(BeNil())
 		})
 
 		It("should send hello command to 1password", func() {
-			err := client.Connect()
+			err := client.Connect(context.Background())
 			Expect(err).To(BeNil())
 
 			mockWebsocketClient.responseString = `{"action":"authBegin"}`
 
-			response, err := client.SendHelloCommand()
+			response, err := client.SendHelloCommand(context.Background())
 
 			Expect(err).To(BeNil())
 			Expect(response).ToNot(BeNil())
 		})
 
-		XIt("should send showPopup command to 1password", func() {
-			err := client.Connect()
+	})
+
+	Describe("Client against a fake helper", func() {
+		var (
+			helper   *fakehelper.Helper
+			stateDir string
+			client   *OnePasswordClient
+			err      error
+		)
+
+		BeforeEach(func() {
+			helper = fakehelper.NewHelper(fakehelper.NewVault(
+				&fakehelper.Item{
+					UUID:     "loginuuid",
+					Title:    "local login",
+					URL:      "sudolikeaboss://local",
+					Username: "username",
+					Password: "password",
+				},
+				&fakehelper.Item{
+					UUID:     "passworduuid",
+					Title:    "prod password",
+					URL:      "sudolikeaboss://prod",
+					Password: "prodpassword",
+				},
+			))
+			Expect(helper.Start()).To(Succeed())
+
+			stateDir, err = ioutil.TempDir("", "sudolikeaboss")
+			Expect(err).To(BeNil())
+
+			client, err = NewClientWithConfig(helper.Configuration(stateDir))
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(client.Close()).To(Succeed())
+			Expect(helper.Close()).To(Succeed())
+			Expect(os.RemoveAll(stateDir)).To(Succeed())
+		})
+
+		Context("with debug logging", func() {
+			var buf *bytes.Buffer
+
+			BeforeEach(func() {
+				buf = &bytes.Buffer{}
+				logrusLogger := logrus.New()
+				logrusLogger.Out = buf
+				logrusLogger.Level = logrus.DebugLevel
+				client.Logger = NewLogger(logrusLogger)
+
+				helper.Vault.Add(&fakehelper.Item{
+					UUID:     "secretuuid",
+					Title:    "secret",
+					Password: "hunter2-do-not-log",
+					Notes:    "notes-do-not-log",
+				})
+			})
+
+			secretStrings := func() []string {
+				encK, hmacK := client.SessionKeys()
+				var secrets []string
+				for _, secret := range [][]byte{client.Secret(), encK, hmacK} {
+					Expect(secret).ToNot(BeEmpty())
+					secrets = append(secrets,
+						string(secret),
+						b64.URLEncoding.EncodeToString(secret),
+						b64.URLEncoding.WithPadding(b64.NoPadding).EncodeToString(secret),
+						b64.StdEncoding.EncodeToString(secret),
+					)
+				}
+				return append(secrets, "hunter2-do-not-log", "notes-do-not-log")
+			}
+
+			It("should never log secret material", func() {
+				_, err := client.Authenticate(context.Background(), true)
+				Expect(err).To(BeNil())
+
+				_, err = client.SendGetItemCommand(context.Background(), "secretuuid", "")
+				Expect(err).To(BeNil())
+
+				Expect(buf.String()).To(ContainSubstring("Sending"))
+				for _, secret := range secretStrings() {
+					Expect(buf.String()).ToNot(ContainSubstring(secret))
+				}
+			})
+
+			It("should log secret material when unsafe", func() {
+				client.Logger.Unsafe = true
+
+				_, err := client.Authenticate(context.Background(), true)
+				Expect(err).To(BeNil())
+
+				_, err = client.SendGetItemCommand(context.Background(), "secretuuid", "")
+				Expect(err).To(BeNil())
+
+				Expect(buf.String()).To(ContainSubstring("hunter2-do-not-log"))
+			})
+		})
+
+		It("should register and authenticate", func() {
+			response, err := client.Authenticate(context.Background(), true)
 			Expect(err).To(BeNil())
+			Expect(response.Action).To(Equal("welcome"))
+		})
 
-			mockWebsocketClient.responseString = SAMPLE_RESPONSE_0
+		It("should fail to authenticate when not registered", func() {
+			_, err := client.Authenticate(context.Background(), false)
+			Expect(err).To(Equal(ErrNotRegistered))
+		})
 
-			response, err := client.SendShowPopupCommand()
+		It("should fail when the registration is rejected", func() {
+			helper.ApproveRegistration = func(string, string) bool { return false }
 
+			_, err := client.Authenticate(context.Background(), true)
+			Expect(errors.Is(err, ErrAuthRejected)).To(BeTrue())
+		})
+
+		It("should work over an in-memory transport", func() {
+			conf := helper.Configuration(stateDir)
+			conf.Dialer = helper.Dial
+
+			client, err := NewClientWithConfig(conf)
+			Expect(err).To(BeNil())
+			defer client.Close()
+
+			_, err = client.Authenticate(context.Background(), true)
+			Expect(err).To(BeNil())
+
+			response, err := client.SendShowPopupCommand(context.Background())
 			Expect(err).To(BeNil())
 			Expect(response.GetPassword()).To(Equal("password"))
 		})
+
+		It("should match replies to commands by their number", func() {
+			dial := transport.PipeDialer(func(t transport.Transport) {
+				defer GinkgoRecover()
+
+				var numbers []int
+				for len(numbers) < 2 {
+					raw, err := t.Receive(context.Background())
+					Expect(err).To(BeNil())
+
+					var command Command
+					Expect(json.Unmarshal(raw, &command)).To(Succeed())
+					numbers = append(numbers, command.Number)
+				}
+
+				// Reply out of order, then send a message that answers
+				// nothing
+				for i := len(numbers) - 1; i >= 0; i-- {
+					reply := fmt.Sprintf(`{"action":"reply%d","number":%d}`, numbers[i], numbers[i])
+					Expect(t.Send(context.Background(), []byte(reply))).To(Succeed())
+				}
+				Expect(t.Send(context.Background(), []byte(`{"action":"notice"}`))).To(Succeed())
+			})
+
+			client, err := NewTransportClient(dial, "sudolikeaboss://local", stateDir, WithStateStore(NewMemoryStateStore()))
+			Expect(err).To(BeNil())
+			defer client.Close()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					command := &Command{Action: "ping"}
+					response, err := client.SendCommand(context.Background(), command)
+					Expect(err).To(BeNil())
+					Expect(response.Number).To(Equal(command.Number))
+					Expect(response.Action).To(Equal(fmt.Sprintf("reply%d", command.Number)))
+				}()
+			}
+			wg.Wait()
+
+			response, err := client.ReceiveJSON(context.Background())
+			Expect(err).To(BeNil())
+			Expect(response.Action).To(Equal("notice"))
+		})
+
+		It("should drop the late reply to a command that timed out", func() {
+			replies := make(chan string)
+			dial := transport.PipeDialer(func(t transport.Transport) {
+				defer GinkgoRecover()
+
+				for {
+					raw, err := t.Receive(context.Background())
+					if err != nil {
+						return
+					}
+
+					var command Command
+					Expect(json.Unmarshal(raw, &command)).To(Succeed())
+
+					action, ok := <-replies
+					if !ok {
+						return
+					}
+					reply := fmt.Sprintf(`{"action":"%s","number":%d}`, action, command.Number)
+					Expect(t.Send(context.Background(), []byte(reply))).To(Succeed())
+				}
+			})
+
+			client, err := NewTransportClient(dial, "sudolikeaboss://local", stateDir, WithStateStore(NewMemoryStateStore()))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			defer close(replies)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			_, err = client.SendCommand(ctx, &Command{Action: "ping"})
+			Expect(errors.Is(err, ErrTimeout)).To(BeTrue())
+
+			replies <- "late"
+			go func() { replies <- "pong" }()
+			response, err := client.SendCommand(context.Background(), &Command{Action: "ping"})
+			Expect(err).To(BeNil())
+			Expect(response.Action).To(Equal("pong"))
+
+			ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err = client.ReceiveJSON(ctx)
+			Expect(errors.Is(err, ErrTimeout)).To(BeTrue())
+		})
+
+		It("should not take an unsolicited message for the reply", func() {
+			dial := transport.PipeDialer(func(t transport.Transport) {
+				defer GinkgoRecover()
+
+				_, err := t.Receive(context.Background())
+				Expect(err).To(BeNil())
+
+				// Neither message is numbered
+				Expect(t.Send(context.Background(), []byte(`{"action":"notice"}`))).To(Succeed())
+				Expect(t.Send(context.Background(), []byte(`{"action":"popupClosed"}`))).To(Succeed())
+			})
+
+			client, err := NewTransportClient(dial, "sudolikeaboss://local", stateDir, WithStateStore(NewMemoryStateStore()))
+			Expect(err).To(BeNil())
+			defer client.Close()
+
+			response, err := client.SendCommand(context.Background(), &Command{Action: "showPopup"})
+			Expect(err).To(BeNil())
+			Expect(response.Action).To(Equal("popupClosed"))
+
+			response, err = client.ReceiveJSON(context.Background())
+			Expect(err).To(BeNil())
+			Expect(response.Action).To(Equal("notice"))
+		})
+
+		Context("when the connection drops", func() {
+			var (
+				conf        *Configuration
+				connections []transport.Transport
+				unreachable bool
+			)
+
+			BeforeEach(func() {
+				connections = nil
+				unreachable = false
+
+				conf = helper.Configuration(stateDir)
+				conf.Backoff = &Backoff{Initial: time.Millisecond, Max: time.Millisecond, Attempts: 3}
+				conf.Dialer = func(ctx context.Context) (transport.Transport, error) {
+					if unreachable {
+						return nil, errors.New("connection refused")
+					}
+
+					t, err := helper.Dial(ctx)
+					connections = append(connections, t)
+					return t, err
+				}
+
+				Expect(client.Close()).To(Succeed())
+				client, err = NewClientWithConfig(conf)
+				Expect(err).To(BeNil())
+
+				_, err = client.Authenticate(context.Background(), true)
+				Expect(err).To(BeNil())
+			})
+
+			It("should reconnect, authenticate again and replay the command", func() {
+				Expect(connections[0].Close()).To(Succeed())
+
+				response, err := client.SendShowPopupCommand(context.Background())
+				Expect(err).To(BeNil())
+				Expect(response.GetPassword()).To(Equal("password"))
+				Expect(connections).To(HaveLen(2))
+			})
+
+			It("should wipe the keys of the lost session", func() {
+				encK, hmacK := client.SessionKeys()
+				Expect(connections[0].Close()).To(Succeed())
+
+				_, err := client.SendShowPopupCommand(context.Background())
+				Expect(err).To(BeNil())
+
+				Expect(encK).To(Equal(make([]byte, len(encK))))
+				Expect(hmacK).To(Equal(make([]byte, len(hmacK))))
+				newEncK, _ := client.SessionKeys()
+				Expect(newEncK).NotTo(Equal(make([]byte, len(newEncK))))
+			})
+
+			It("should reconnect when authenticating", func() {
+				Expect(connections[0].Close()).To(Succeed())
+
+				response, err := client.Authenticate(context.Background(), false)
+				Expect(err).To(BeNil())
+				Expect(response.Action).To(Equal("welcome"))
+				Expect(connections).To(HaveLen(2))
+			})
+
+			It("should give up when the helper doesn't come back", func() {
+				Expect(connections[0].Close()).To(Succeed())
+				unreachable = true
+
+				_, err := client.SendShowPopupCommand(context.Background())
+				Expect(errors.Is(err, ErrHelperUnreachable)).To(BeTrue())
+
+				unreachable = false
+				_, err = client.SendShowPopupCommand(context.Background())
+				Expect(err).To(BeNil())
+			})
+
+			It("should not reconnect when disabled", func() {
+				conf.Backoff = &Backoff{}
+				Expect(client.Close()).To(Succeed())
+				client, err = NewClientWithConfig(conf)
+				Expect(err).To(BeNil())
+				_, err = client.Authenticate(context.Background(), false)
+				Expect(err).To(BeNil())
+
+				Expect(connections[len(connections)-1].Close()).To(Succeed())
+
+				_, err = client.SendShowPopupCommand(context.Background())
+				Expect(errors.Is(err, ErrConnectionLost)).To(BeTrue())
+			})
+		})
+
+		It("should fail when the helper is unreachable", func() {
+			conf := helper.Configuration(stateDir)
+			Expect(helper.Close()).To(Succeed())
+
+			_, err := NewClientWithConfig(conf)
+			Expect(errors.Is(err, ErrHelperUnreachable)).To(BeTrue())
+		})
+
+		Context("when registered", func() {
+			BeforeEach(func() {
+				_, err := client.Authenticate(context.Background(), true)
+				Expect(err).To(BeNil())
+
+				Expect(client.Close()).To(Succeed())
+				client, err = NewClientWithConfig(helper.Configuration(stateDir))
+				Expect(err).To(BeNil())
+
+				_, err = client.Authenticate(context.Background(), false)
+				Expect(err).To(BeNil())
+			})
+
+			It("should send showPopup command to 1password", func() {
+				response, err := client.SendShowPopupCommand(context.Background())
+
+				Expect(err).To(BeNil())
+				Expect(response.GetPassword()).To(Equal("password"))
+			})
+
+			It("should receive password items for the default host", func() {
+				client.DefaultHost = "sudolikeaboss://prod"
+
+				response, err := client.SendShowPopupCommand(context.Background())
+
+				Expect(err).To(BeNil())
+				Expect(response.Payload.Action).To(Equal("fillPassword"))
+				Expect(response.GetPassword()).To(Equal("prodpassword"))
+			})
+
+			It("should get the notes and totp of an item", func() {
+				helper.Vault.Add(&fakehelper.Item{
+					UUID:     "totpuuid",
+					Title:    "totp",
+					Password: "totppassword",
+					Notes:    "some notes",
+					TOTP:     "otpauth://totp/test?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+				})
+
+				response, err := client.SendGetItemCommand(context.Background(), "totpuuid", "")
+				Expect(err).To(BeNil())
+
+				Expect(response.GetField("notes")).To(Equal("some notes"))
+
+				code, err := response.GetField("totp")
+				Expect(err).To(BeNil())
+				Expect(code).To(MatchRegexp("^[0-9]{6}$"))
+			})
+
+			It("should get an item by uuid", func() {
+				response, err := client.SendGetItemCommand(context.Background(), "passworduuid", "")
+
+				Expect(err).To(BeNil())
+				Expect(response.GetPassword()).To(Equal("prodpassword"))
+			})
+
+			It("should get an item by title", func() {
+				response, err := client.SendGetItemCommand(context.Background(), "", "local login")
+
+				Expect(err).To(BeNil())
+				Expect(response.GetPassword()).To(Equal("password"))
+			})
+
+			It("should fail to get an unknown item", func() {
+				_, err := client.SendGetItemCommand(context.Background(), "missing", "")
+
+				Expect(err).To(Equal(ErrItemNotFound))
+			})
+
+			It("should give up quickly on a helper that can't look up items", func() {
+				defer SetItemLookupTimeout(100 * time.Millisecond)()
+				helper.Unsupported = []string{"getItem"}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+
+				start := time.Now()
+				_, err := client.SendGetItemCommand(ctx, "passworduuid", "")
+
+				Expect(errors.Is(err, ErrItemLookupUnsupported)).To(BeTrue())
+				Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+			})
+
+			It("should serve commands from several goroutines at once", func() {
+				var wg sync.WaitGroup
+				for i := 0; i < 8; i++ {
+					uuid, password := "passworduuid", "prodpassword"
+					if i%2 == 0 {
+						uuid, password = "loginuuid", "password"
+					}
+
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						defer wg.Done()
+
+						response, err := client.SendGetItemCommand(context.Background(), uuid, "")
+						Expect(err).To(BeNil())
+						Expect(response.GetPassword()).To(Equal(password))
+					}()
+				}
+				wg.Wait()
+			})
+
+			It("should fail to register again", func() {
+				_, err := client.Authenticate(context.Background(), true)
+
+				Expect(err).To(Equal(ErrAlreadyRegistered))
+			})
+
+			It("should probe a registration without loading the state", func() {
+				state, err := NewFileStateStore(stateDir).Load()
+				Expect(err).To(BeNil())
+
+				emptyDir, err := ioutil.TempDir("", "sudolikeaboss")
+				Expect(err).To(BeNil())
+				defer os.RemoveAll(emptyDir)
+				configuration := helper.Configuration(emptyDir)
+
+				Expect(Probe(context.Background(), configuration, state.ExtID)).To(Equal("authBegin"))
+				Expect(Probe(context.Background(), configuration, "unknown")).To(Equal("authNew"))
+				Expect(NewFileStateStore(emptyDir).Load()).To(BeNil())
+			})
+
+			It("should wipe the keys and refuse commands once closed", func() {
+				secret := client.Secret()
+				encK, hmacK := client.SessionKeys()
+
+				Expect(client.Close()).To(Succeed())
+				Expect(client.Close()).To(Succeed())
+
+				for _, key := range [][]byte{secret, encK, hmacK} {
+					Expect(key).To(Equal(make([]byte, len(key))))
+				}
+				Expect(client.Secret()).To(BeNil())
+
+				_, err := client.SendShowPopupCommand(context.Background())
+				Expect(err).To(Equal(ErrClosed))
+				_, err = client.Authenticate(context.Background(), false)
+				Expect(err).To(Equal(ErrClosed))
+				Expect(client.Connect(context.Background())).To(Equal(ErrClosed))
+			})
+
+			It("should time out waiting for the popup", func() {
+				release := make(chan struct{})
+				defer close(release)
+				helper.Popup = func(string, *fakehelper.Vault) *fakehelper.Item {
+					<-release
+					return nil
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+
+				_, err := client.SendShowPopupCommand(ctx)
+				Expect(errors.Is(err, ErrTimeout)).To(BeTrue())
+			})
+
+			It("should report a closed popup as cancelled", func() {
+				helper.Popup = func(string, *fakehelper.Vault) *fakehelper.Item { return nil }
+
+				_, err := client.SendShowPopupCommand(context.Background())
+
+				Expect(err).To(Equal(ErrUserCancelled))
+			})
+		})
 	})
 })
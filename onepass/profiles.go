@@ -0,0 +1,176 @@
+package onepass
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"sort"
+)
+
+// ProfilesFileName is the file in the state directory holding all profiles.
+const ProfilesFileName = "profiles.json"
+
+// Profile is a named registration with its own helper connection settings.
+// Empty settings fall back to the regular configuration.
+type Profile struct {
+	WebsocketURI      string           `json:"websocketUri,omitempty"`
+	WebsocketProtocol string           `json:"websocketProtocol,omitempty"`
+	WebsocketOrigin   string           `json:"websocketOrigin,omitempty"`
+	DefaultHost       string           `json:"defaultHost,omitempty"`
+	State             *StateFileConfig `json:"state,omitempty"`
+}
+
+// Apply overrides configuration with the settings of the profile.
+func (profile *Profile) Apply(configuration *Configuration) {
+	if profile.WebsocketURI != "" {
+		configuration.WebsocketURI = profile.WebsocketURI
+	}
+	if profile.WebsocketProtocol != "" {
+		configuration.WebsocketProtocol = profile.WebsocketProtocol
+	}
+	if profile.WebsocketOrigin != "" {
+		configuration.WebsocketOrigin = profile.WebsocketOrigin
+	}
+	if profile.DefaultHost != "" {
+		configuration.DefaultHost = profile.DefaultHost
+	}
+}
+
+// Profiles is the content of the profiles file.
+type Profiles struct {
+	Profiles map[string]*Profile `json:"profiles"`
+}
+
+// LoadProfiles reads the profiles file at profilesPath. A missing file has
+// no profiles.
+func LoadProfiles(profilesPath string) (*Profiles, error) {
+	profiles := Profiles{Profiles: make(map[string]*Profile)}
+
+	exists, err := Exists(profilesPath)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return &profiles, nil
+	}
+
+	profilesStr, err := ioutil.ReadFile(profilesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(profilesStr, &profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	if profiles.Profiles == nil {
+		profiles.Profiles = make(map[string]*Profile)
+	}
+	return &profiles, nil
+}
+
+func (profiles *Profiles) Save(profilesPath string) error {
+	err := EnsureDir(path.Dir(profilesPath))
+	if err != nil {
+		return err
+	}
+
+	profilesStr, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(profilesPath, profilesStr, 0600)
+}
+
+// UpdateProfiles changes the profiles file with f while holding the lock
+// every ProfileStateStore in its directory uses.
+func UpdateProfiles(profilesPath string, f func(profiles *Profiles) error) error {
+	unlock, err := LockFile(profilesPath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	profiles, err := LoadProfiles(profilesPath)
+	if err != nil {
+		return err
+	}
+
+	err = f(profiles)
+	if err != nil {
+		return err
+	}
+
+	return profiles.Save(profilesPath)
+}
+
+// Names returns the sorted profile names.
+func (profiles *Profiles) Names() []string {
+	names := make([]string, 0, len(profiles.Profiles))
+	for name := range profiles.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProfileStateStore keeps the pairing state of one profile in the profiles
+// file, next to its settings. Saving creates the profile if needed.
+type ProfileStateStore struct {
+	Path string
+	Name string
+}
+
+func NewProfileStateStore(stateDirectory string, name string) *ProfileStateStore {
+	return &ProfileStateStore{Path: path.Join(stateDirectory, ProfilesFileName), Name: name}
+}
+
+// Lock locks the whole profiles file, which all profiles are saved to.
+func (store *ProfileStateStore) Lock() (func(), error) {
+	return LockFile(store.Path + ".lock")
+}
+
+func (store *ProfileStateStore) Load() (*StateFileConfig, error) {
+	profiles, err := LoadProfiles(store.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, ok := profiles.Profiles[store.Name]
+	if !ok {
+		return nil, nil
+	}
+	return profile.State, nil
+}
+
+func (store *ProfileStateStore) Save(state *StateFileConfig) error {
+	return store.update(func(profile *Profile) {
+		saved := *state
+		profile.State = &saved
+	})
+}
+
+func (store *ProfileStateStore) Delete() error {
+	return store.update(func(profile *Profile) {
+		profile.State = nil
+	})
+}
+
+func (store *ProfileStateStore) update(f func(profile *Profile)) error {
+	profiles, err := LoadProfiles(store.Path)
+	if err != nil {
+		return err
+	}
+
+	profile, ok := profiles.Profiles[store.Name]
+	if !ok {
+		profile = &Profile{}
+		profiles.Profiles[store.Name] = profile
+	}
+
+	f(profile)
+
+	return profiles.Save(store.Path)
+}
@@ -0,0 +1,100 @@
+package onepass
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Backoff paces the attempts to reconnect to the helper. The delay starts at
+// Initial and doubles after every failed attempt, up to Max.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	// Attempts is how often dialing is tried, 0 never reconnects.
+	Attempts int
+}
+
+// DefaultBackoff gives a restarting helper a few seconds to come back.
+var DefaultBackoff = Backoff{
+	Initial:  100 * time.Millisecond,
+	Max:      2 * time.Second,
+	Attempts: 6,
+}
+
+// WithBackoff paces reconnecting with backoff instead of DefaultBackoff.
+func WithBackoff(backoff Backoff) ClientOption {
+	return func(client *OnePasswordClient) {
+		client.backoff = backoff
+	}
+}
+
+// lostConnection reports whether err lost the connection and reconnecting
+// is worth a try.
+func (client *OnePasswordClient) lostConnection(err error) bool {
+	return errors.Is(err, ErrConnectionLost) && client.backoff.Attempts > 0 && !client.isClosed()
+}
+
+// recover reconnects and authenticates again once the connection lost has
+// failed, unless another goroutine did so already.
+func (client *OnePasswordClient) recover(ctx context.Context, lost *connection) error {
+	client.sessionLock.Lock()
+	defer client.sessionLock.Unlock()
+
+	client.mu.Lock()
+	current := client.conn
+	client.mu.Unlock()
+
+	if current != lost && current != nil && current.failure() == nil {
+		return nil
+	}
+
+	err := client.reconnect(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.authenticate(ctx, false)
+	return err
+}
+
+// reconnect drops the lost connection with the keys of its session and dials
+// again until it succeeds, the attempts run out or ctx is done. It needs the session lock held for
+// writing.
+func (client *OnePasswordClient) reconnect(ctx context.Context) error {
+	client.Logger.Debugf("Lost the connection to the helper, reconnecting")
+
+	client.mu.Lock()
+	lost := client.conn
+	client.conn = nil
+	client.mu.Unlock()
+
+	if lost != nil {
+		_ = lost.close()
+	}
+	client.wipeSession()
+
+	delay := client.backoff.Initial
+	var err error
+	for attempt := 0; attempt < client.backoff.Attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return timeoutError(ctx.Err())
+			}
+
+			delay *= 2
+			if delay > client.backoff.Max {
+				delay = client.backoff.Max
+			}
+		}
+
+		err = client.Connect(ctx)
+		if err == nil || !errors.Is(err, ErrHelperUnreachable) {
+			return err
+		}
+		client.Logger.Debugf("Reconnecting failed: %s", err)
+	}
+	return err
+}
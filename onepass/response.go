@@ -4,44 +4,70 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
 type Response struct {
 	Action  string          `json:"action"`
+	Number  int             `json:"number,omitempty"`
 	Version string          `json:"version"`
 	Payload ResponsePayload `json:"payload"`
+
+	// session holds the keys the payload was encrypted with.
+	session *sessionKeys
 }
 
 func (response *Response) GetPassword() (string, error) {
+	item, err := response.GetItem()
+	if err != nil {
+		return "", err
+	}
+
+	return item.GetPassword()
+}
+
+// GetField returns the named field of the filled item. See Item.GetField.
+func (response *Response) GetField(name string) (string, error) {
+	item, err := response.GetItem()
+	if err != nil {
+		return "", err
+	}
+
+	return item.GetField(name)
+}
+
+// GetItem parses the item of a fillItem response.
+func (response *Response) GetItem() (Item, error) {
 	if response.Action != "fillItem" {
-		errorMsg := fmt.Sprintf("Response action \"%s\" does not have a password", response.Action)
-		return "", errors.New(errorMsg)
+		errorMsg := fmt.Sprintf("Response action \"%s\" does not have an item", response.Action)
+		return nil, errors.New(errorMsg)
+	}
+
+	if response.Payload.Item == nil {
+		return nil, errors.New("response does not have an item")
 	}
 
 	itemBytes := []byte(*response.Payload.Item)
-	var item Item
 
 	switch response.Payload.Action {
 	case "fillLogin":
 		var loginItem LoginItem
 		if err := json.Unmarshal(itemBytes, &loginItem); err != nil {
-			return "", err
+			return nil, err
 		}
-		item = loginItem
+		return loginItem, nil
 
 	case "fillPassword":
 		var passwordItem PasswordItem
 		if err := json.Unmarshal(itemBytes, &passwordItem); err != nil {
-			return "", err
+			return nil, err
 		}
-		item = passwordItem
-
-	default:
-		errorMsg := fmt.Sprintf("Payload action \"%s\" does not have a password", response.Payload.Action)
-		return "", errors.New(errorMsg)
+		return passwordItem, nil
 	}
 
-	return item.GetPassword()
+	errorMsg := fmt.Sprintf("Payload action \"%s\" does not have an item", response.Payload.Action)
+	return nil, errors.New(errorMsg)
 }
 
 type ResponsePayload struct {
@@ -60,8 +86,23 @@ type ResponsePayload struct {
 	Action         string                 `json:"action"`
 }
 
+// Item is a 1Password item returned by the helper.
+//
+// GetField understands the following names:
+//
+//	password          the password
+//	username          the username of a login
+//	notes             the notes of the item
+//	totp              the current one-time password
+//	htmlForm.<key>    a property of the login's HTML form, e.g. htmlForm.htmlAction
+//	<anything else>   a login field with that id or name, or a custom field
+//	                  with that name or title
 type Item interface {
+	GetUUID() string
+	GetTitle() string
+	GetURL() string
 	GetPassword() (string, error)
+	GetField(name string) (string, error)
 }
 
 type LoginItem struct {
@@ -71,19 +112,67 @@ type LoginItem struct {
 	SecureContents LoginItemSecureContents `json:"secureContents"`
 }
 
+func (item LoginItem) GetUUID() string {
+	return item.UUID
+}
+
+func (item LoginItem) GetTitle() string {
+	return overviewString(item.Overview, "title")
+}
+
+func (item LoginItem) GetURL() string {
+	return overviewString(item.Overview, "url")
+}
+
 func (item LoginItem) GetPassword() (string, error) {
+	return item.designatedField("password")
+}
+
+func (item LoginItem) GetField(name string) (string, error) {
+	switch name {
+	case "password", "username":
+		return item.designatedField(name)
+	case "notes":
+		if item.SecureContents.NotesPlain == "" {
+			return "", noFieldError(name)
+		}
+		return item.SecureContents.NotesPlain, nil
+	case "totp":
+		return totpFromSections(item.SecureContents.Sections)
+	}
+
+	if strings.HasPrefix(name, "htmlForm.") {
+		value, ok := item.SecureContents.HTMLForm[strings.TrimPrefix(name, "htmlForm.")]
+		if !ok {
+			return "", noFieldError(name)
+		}
+		return fmt.Sprint(value), nil
+	}
+
+	for _, fieldObj := range item.SecureContents.Fields {
+		if fieldObj["id"] == name || fieldObj["name"] == name {
+			return fieldObj["value"], nil
+		}
+	}
+
+	return sectionField(item.SecureContents.Sections, name)
+}
+
+func (item LoginItem) designatedField(designation string) (string, error) {
 	for _, fieldObj := range item.SecureContents.Fields {
-		if fieldObj["designation"] == "password" {
+		if fieldObj["designation"] == designation {
 			return fieldObj["value"], nil
 		}
 	}
 
-	return "", errors.New("no password found in the item")
+	return "", noFieldError(designation)
 }
 
 type LoginItemSecureContents struct {
-	HTMLForm map[string]interface{} `json:"htmlForm"`
-	Fields   []map[string]string    `json:"fields"`
+	HTMLForm   map[string]interface{} `json:"htmlForm"`
+	Fields     []map[string]string    `json:"fields"`
+	NotesPlain string                 `json:"notesPlain"`
+	Sections   []ItemSection          `json:"sections"`
 }
 
 type PasswordItem struct {
@@ -92,12 +181,97 @@ type PasswordItem struct {
 	SecureContents PasswordItemSecureContents `json:"secureContents"`
 }
 
+func (item PasswordItem) GetUUID() string {
+	return item.UUID
+}
+
+func (item PasswordItem) GetTitle() string {
+	return overviewString(item.Overview, "title")
+}
+
+func (item PasswordItem) GetURL() string {
+	return overviewString(item.Overview, "url")
+}
+
 func (item PasswordItem) GetPassword() (string, error) {
+	if item.SecureContents.Password == "" {
+		return "", ErrNoPassword
+	}
 	return item.SecureContents.Password, nil
 }
 
+func (item PasswordItem) GetField(name string) (string, error) {
+	switch name {
+	case "password":
+		return item.GetPassword()
+	case "notes":
+		if item.SecureContents.NotesPlain == "" {
+			return "", noFieldError(name)
+		}
+		return item.SecureContents.NotesPlain, nil
+	case "totp":
+		return totpFromSections(item.SecureContents.Sections)
+	}
+
+	return sectionField(item.SecureContents.Sections, name)
+}
+
 type PasswordItemSecureContents struct {
-	Password string `json:"password"`
+	Password   string        `json:"password"`
+	NotesPlain string        `json:"notesPlain"`
+	Sections   []ItemSection `json:"sections"`
+}
+
+// ItemSection holds the custom fields of an item.
+type ItemSection struct {
+	Name   string             `json:"name"`
+	Title  string             `json:"title"`
+	Fields []ItemSectionField `json:"fields"`
+}
+
+type ItemSectionField struct {
+	Kind  string      `json:"k"`
+	Name  string      `json:"n"`
+	Title string      `json:"t"`
+	Value interface{} `json:"v"`
+}
+
+// isTOTP reports whether the field holds a one-time password secret. 1Password
+// names these fields TOTP_<id>.
+func (field ItemSectionField) isTOTP() bool {
+	return strings.HasPrefix(field.Name, "TOTP_")
+}
+
+func sectionField(sections []ItemSection, name string) (string, error) {
+	for _, section := range sections {
+		for _, field := range section.Fields {
+			if field.Name == name || field.Title == name {
+				return fmt.Sprint(field.Value), nil
+			}
+		}
+	}
+
+	return "", noFieldError(name)
+}
+
+func totpFromSections(sections []ItemSection) (string, error) {
+	for _, section := range sections {
+		for _, field := range section.Fields {
+			if field.isTOTP() {
+				return TOTP(fmt.Sprint(field.Value), time.Now())
+			}
+		}
+	}
+
+	return "", noFieldError("totp")
+}
+
+func overviewString(overview map[string]interface{}, key string) string {
+	value, ok := overview[key].(string)
+	if !ok {
+		return ""
+	}
+	return value
 }
 
 func LoadResponse(rawResponseStr string) (*Response, error) {
@@ -0,0 +1,216 @@
+package onepass
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	b64 "encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Kinds of secret storage recorded in the state file.
+const (
+	PlaintextStorage  = "plaintext"
+	PassphraseStorage = "passphrase"
+	KeyringStorage    = "keyring"
+)
+
+// ErrWrongPassphrase is returned when the pairing secret can't be decrypted.
+var ErrWrongPassphrase = errors.New("wrong passphrase for the sudolikeaboss state")
+
+// SecretStore protects the pairing secret, which is equivalent to full access
+// to the 1Password helper, while it is at rest.
+type SecretStore interface {
+	// Kind is recorded in the state file so the secret can be opened again.
+	Kind() string
+	// Seal stores secret and returns what to keep in StateFileConfig.Secret.
+	Seal(extID string, secret []byte) (string, error)
+	// Open returns the secret sealed for extID.
+	Open(extID string, sealed string) ([]byte, error)
+	// Delete removes whatever Seal stored outside of the state file.
+	Delete(extID string, sealed string) error
+}
+
+// NewSecretStore returns the store for kind. passphrase is only used by
+// PassphraseStorage and asked for lazily.
+func NewSecretStore(kind string, passphrase func() ([]byte, error)) (SecretStore, error) {
+	switch kind {
+	case "", PlaintextStorage:
+		return PlaintextSecretStore{}, nil
+	case PassphraseStorage:
+		if passphrase == nil {
+			return nil, errors.New("passphrase storage needs a passphrase")
+		}
+		return &PassphraseSecretStore{Passphrase: passphrase}, nil
+	case KeyringStorage:
+		return &KeyringSecretStore{Keyring: NewSystemKeyring()}, nil
+	}
+
+	return nil, fmt.Errorf("unknown secret storage %q", kind)
+}
+
+var base64urlWithoutPadding = b64.URLEncoding.WithPadding(b64.NoPadding)
+
+// PlaintextSecretStore keeps the secret base64 encoded in the state file. It
+// is what sudolikeaboss has always done.
+type PlaintextSecretStore struct{}
+
+func (PlaintextSecretStore) Kind() string {
+	return PlaintextStorage
+}
+
+func (PlaintextSecretStore) Seal(extID string, secret []byte) (string, error) {
+	return base64urlWithoutPadding.EncodeToString(secret), nil
+}
+
+func (PlaintextSecretStore) Open(extID string, sealed string) ([]byte, error) {
+	return base64urlWithoutPadding.DecodeString(sealed)
+}
+
+func (PlaintextSecretStore) Delete(extID string, sealed string) error {
+	return nil
+}
+
+// scrypt parameters recommended for interactive logins.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptPrefix = "scrypt"
+)
+
+// PassphraseSecretStore encrypts the secret with AES-256-GCM under a key
+// derived from a passphrase with scrypt. The extID is authenticated along
+// with it, so a sealed secret can't be moved to another registration.
+type PassphraseSecretStore struct {
+	Passphrase func() ([]byte, error)
+}
+
+func (store *PassphraseSecretStore) Kind() string {
+	return PassphraseStorage
+}
+
+func (store *PassphraseSecretStore) Seal(extID string, secret []byte) (string, error) {
+	salt, err := GenerateRandomBytes(16)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := store.aead(salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := GenerateRandomBytes(aead.NonceSize())
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext := aead.Seal(nil, nonce, secret, []byte(extID))
+
+	return strings.Join([]string{
+		scryptPrefix,
+		base64urlWithoutPadding.EncodeToString(salt),
+		base64urlWithoutPadding.EncodeToString(nonce),
+		base64urlWithoutPadding.EncodeToString(ciphertext),
+	}, "$"), nil
+}
+
+func (store *PassphraseSecretStore) Open(extID string, sealed string) ([]byte, error) {
+	parts := strings.Split(sealed, "$")
+	if len(parts) != 4 || parts[0] != scryptPrefix {
+		return nil, errors.New("malformed passphrase protected secret")
+	}
+
+	decoded := make([][]byte, 3)
+	for i, part := range parts[1:] {
+		value, err := base64urlWithoutPadding.DecodeString(part)
+		if err != nil {
+			return nil, err
+		}
+		decoded[i] = value
+	}
+	salt, nonce, ciphertext := decoded[0], decoded[1], decoded[2]
+
+	aead, err := store.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("malformed passphrase protected secret")
+	}
+
+	secret, err := aead.Open(nil, nonce, ciphertext, []byte(extID))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return secret, nil
+}
+
+func (store *PassphraseSecretStore) Delete(extID string, sealed string) error {
+	return nil
+}
+
+func (store *PassphraseSecretStore) aead(salt []byte) (cipher.AEAD, error) {
+	passphrase, err := store.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("the passphrase is empty")
+	}
+
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// KeyringService is the service name secrets are stored under in the
+// keyring.
+const KeyringService = "sudolikeaboss"
+
+// KeyringSecretStore keeps the secret in the OS keyring, using the extID as
+// the account. Only a reference is written to the state file.
+type KeyringSecretStore struct {
+	Keyring Keyring
+}
+
+func (store *KeyringSecretStore) Kind() string {
+	return KeyringStorage
+}
+
+func (store *KeyringSecretStore) Seal(extID string, secret []byte) (string, error) {
+	err := store.Keyring.Set(KeyringService, extID, base64urlWithoutPadding.EncodeToString(secret))
+	if err != nil {
+		return "", err
+	}
+	return KeyringStorage + ":" + extID, nil
+}
+
+func (store *KeyringSecretStore) Open(extID string, sealed string) ([]byte, error) {
+	secret, err := store.Keyring.Get(KeyringService, extID)
+	if err != nil {
+		return nil, err
+	}
+	return base64urlWithoutPadding.DecodeString(secret)
+}
+
+func (store *KeyringSecretStore) Delete(extID string, sealed string) error {
+	err := store.Keyring.Delete(KeyringService, extID)
+	if err == ErrKeyringItemNotFound {
+		return nil
+	}
+	return err
+}
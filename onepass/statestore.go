@@ -0,0 +1,240 @@
+package onepass
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+)
+
+// StateStore persists the pairing state of a client.
+type StateStore interface {
+	// Lock blocks other clients from changing the state until the returned
+	// function is called. Load, Save and Delete don't lock by themselves, so
+	// a client can create the state without racing another one.
+	Lock() (func(), error)
+	// Load returns the saved state, or nil if nothing was saved yet.
+	Load() (*StateFileConfig, error)
+	Save(state *StateFileConfig) error
+	Delete() error
+}
+
+// FileStateStore keeps the state as JSON in a file.
+type FileStateStore struct {
+	Path string
+}
+
+// NewFileStateStore returns the store for state.json in stateDirectory.
+func NewFileStateStore(stateDirectory string) *FileStateStore {
+	return &FileStateStore{Path: path.Join(stateDirectory, "state.json")}
+}
+
+// Lock locks a file next to the state file, shared by every process using
+// the same state directory.
+func (store *FileStateStore) Lock() (func(), error) {
+	return LockFile(store.Path + ".lock")
+}
+
+func (store *FileStateStore) Load() (*StateFileConfig, error) {
+	stateFileExists, err := Exists(store.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !stateFileExists {
+		return nil, nil
+	}
+
+	stateFileStr, err := ioutil.ReadFile(store.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var stateFileConfig StateFileConfig
+
+	err = json.Unmarshal(stateFileStr, &stateFileConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stateFileConfig, nil
+}
+
+func (store *FileStateStore) Save(state *StateFileConfig) error {
+	err := EnsureDir(path.Dir(store.Path))
+	if err != nil {
+		return err
+	}
+
+	stateFileStr, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(store.Path, stateFileStr, 0600)
+}
+
+// Delete overwrites the state file with zeros before removing it, so the
+// secret doesn't linger in the freed blocks.
+func (store *FileStateStore) Delete() error {
+	file, err := os.OpenFile(store.Path, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err == nil {
+		_, err = file.WriteAt(make([]byte, info.Size()), 0)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return os.Remove(store.Path)
+}
+
+// ForgetState deletes the saved state and the secret it refers to.
+// secretStore is used for the secret if it is of the kind that sealed it;
+// keyring secrets are deleted from the system keyring otherwise.
+func ForgetState(stateStore StateStore, secretStore SecretStore) error {
+	unlock, err := stateStore.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := stateStore.Load()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return nil
+	}
+
+	storage := state.Storage
+	if storage == "" {
+		storage = PlaintextStorage
+	}
+
+	if storage == KeyringStorage && (secretStore == nil || secretStore.Kind() != KeyringStorage) {
+		secretStore = &KeyringSecretStore{Keyring: NewSystemKeyring()}
+	}
+
+	if secretStore != nil && secretStore.Kind() == storage {
+		err = secretStore.Delete(state.ExtID, state.Secret)
+		if err != nil {
+			return err
+		}
+	}
+
+	return stateStore.Delete()
+}
+
+// MemoryStateStore keeps the state for the lifetime of the process.
+type MemoryStateStore struct {
+	// lock is held between Lock and unlock, mu only while state is used.
+	lock  sync.Mutex
+	mu    sync.Mutex
+	state *StateFileConfig
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{}
+}
+
+func (store *MemoryStateStore) Lock() (func(), error) {
+	store.lock.Lock()
+	return store.lock.Unlock, nil
+}
+
+func (store *MemoryStateStore) Load() (*StateFileConfig, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.state == nil {
+		return nil, nil
+	}
+	state := *store.state
+	return &state, nil
+}
+
+func (store *MemoryStateStore) Save(state *StateFileConfig) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	saved := *state
+	store.state = &saved
+	return nil
+}
+
+func (store *MemoryStateStore) Delete() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.state = nil
+	return nil
+}
+
+// DefaultStateEnvironmentVariable is read by EnvStateStore unless another
+// name is given.
+const DefaultStateEnvironmentVariable = "SUDOLIKEABOSS_STATE"
+
+// ErrReadOnlyStateStore is returned when saving to a store that can't be
+// written, such as the environment.
+var ErrReadOnlyStateStore = errors.New("the state store is read only")
+
+// EnvStateStore reads the state JSON, as found in state.json, from an
+// environment variable. It is meant for containers that are provisioned
+// with an existing registration, so it can't save new state.
+type EnvStateStore struct {
+	Name string
+}
+
+func NewEnvStateStore(name string) *EnvStateStore {
+	if name == "" {
+		name = DefaultStateEnvironmentVariable
+	}
+	return &EnvStateStore{Name: name}
+}
+
+// Lock doesn't lock anything, the environment can't be changed by other
+// processes.
+func (store *EnvStateStore) Lock() (func(), error) {
+	return func() {}, nil
+}
+
+func (store *EnvStateStore) Load() (*StateFileConfig, error) {
+	value := os.Getenv(store.Name)
+	if value == "" {
+		return nil, nil
+	}
+
+	var stateFileConfig StateFileConfig
+
+	err := json.Unmarshal([]byte(value), &stateFileConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", store.Name, err)
+	}
+
+	return &stateFileConfig, nil
+}
+
+func (store *EnvStateStore) Save(state *StateFileConfig) error {
+	return fmt.Errorf("%w: set %s to an existing registration", ErrReadOnlyStateStore, store.Name)
+}
+
+func (store *EnvStateStore) Delete() error {
+	return os.Unsetenv(store.Name)
+}
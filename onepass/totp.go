@@ -0,0 +1,100 @@
+package onepass
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TOTP generates the RFC 6238 one-time password described by an otpauth://
+// URI (as stored by 1Password) for the time t. A bare base32 secret is also
+// accepted and uses the default of 6 digits, a 30 second period and SHA1.
+func TOTP(uri string, t time.Time) (string, error) {
+	secret := uri
+	digits := 6
+	period := 30
+	algorithm := sha1.New
+
+	if strings.HasPrefix(uri, "otpauth://") {
+		parsedURI, err := url.Parse(uri)
+		if err != nil {
+			return "", err
+		}
+		query := parsedURI.Query()
+
+		secret = query.Get("secret")
+
+		if value := query.Get("digits"); value != "" {
+			digits, err = strconv.Atoi(value)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		if value := query.Get("period"); value != "" {
+			period, err = strconv.Atoi(value)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		switch strings.ToUpper(query.Get("algorithm")) {
+		case "", "SHA1":
+		case "SHA256":
+			algorithm = sha256.New
+		case "SHA512":
+			algorithm = sha512.New
+		default:
+			return "", fmt.Errorf("unsupported totp algorithm %s", query.Get("algorithm"))
+		}
+	}
+
+	if secret == "" {
+		return "", errors.New("totp secret is empty")
+	}
+	if digits < 1 || digits > 10 || period < 1 {
+		return "", errors.New("invalid totp parameters")
+	}
+
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hotp(algorithm, key, uint64(t.Unix())/uint64(period), digits), nil
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	secret = strings.TrimRight(secret, "=")
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+}
+
+func hotp(algorithm func() hash.Hash, key []byte, counter uint64, digits int) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, counter)
+
+	h := hmac.New(algorithm, key)
+	_, _ = h.Write(message)
+	sum := h.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint64(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, uint64(code)%modulo)
+}
@@ -9,7 +9,9 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
 )
 
 func Exists(testPath string) (bool, error) {
@@ -27,6 +29,62 @@ func EnsureDir(ensurePath string) error {
 	return os.MkdirAll(ensurePath, 0700)
 }
 
+// WriteFileAtomic writes data to a temporary file next to filePath and
+// renames it into place, so readers never see a partially written file.
+func WriteFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	file, err := ioutil.TempFile(path.Dir(filePath), "."+path.Base(filePath)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := file.Name()
+
+	err = file.Chmod(perm)
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, filePath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// LockFile blocks until it holds an exclusive lock on lockPath, which is
+// created if needed. The lock is held until the returned function is called
+// and is respected by other processes.
+func LockFile(lockPath string) (func(), error) {
+	err := EnsureDir(path.Dir(lockPath))
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFile(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("locking %s: %s", lockPath, err)
+	}
+
+	return func() {
+		_ = unlockFile(file)
+		file.Close()
+	}, nil
+}
+
 func HmacSha256(key []byte, dataToSign ...[]byte) []byte {
 	h := hmac.New(sha256.New, key)
 	for _, data := range dataToSign {
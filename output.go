@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+)
+
+// Output formats accepted by --output. Without one the field is printed on a
+// line of its own, as sudolikeaboss always has.
+const (
+	outputDefault = ""
+	outputRaw     = "raw"
+	outputJSON    = "json"
+	outputEnv     = "env"
+	outputExport  = "export"
+)
+
+var outputFormats = []string{outputRaw, outputJSON, outputEnv, outputExport}
+
+func isOutputFormat(format string) bool {
+	if format == outputDefault {
+		return true
+	}
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// printsField tells whether format prints the field picked with --field,
+// rather than all the credentials.
+func printsField(format string) bool {
+	return format == outputDefault || format == outputRaw
+}
+
+// Credentials is what the json, env and export formats print for an item.
+type Credentials struct {
+	UUID     string `json:"uuid"`
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// NewCredentials collects the credentials of item. Password items have no
+// username, so it is left empty.
+func NewCredentials(item onepass.Item) (*Credentials, error) {
+	password, err := item.GetPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	username, _ := item.GetField("username")
+
+	return &Credentials{
+		UUID:     item.GetUUID(),
+		Title:    item.GetTitle(),
+		URL:      item.GetURL(),
+		Username: username,
+		Password: password,
+	}, nil
+}
+
+// env returns the credentials as ordered KEY, value pairs.
+func (credentials *Credentials) env() [][2]string {
+	return [][2]string{
+		{"UUID", credentials.UUID},
+		{"TITLE", credentials.Title},
+		{"URL", credentials.URL},
+		{"USERNAME", credentials.Username},
+		{"PASSWORD", credentials.Password},
+	}
+}
+
+// writeItem prints item to w in the given format. The default and raw formats
+// print only the requested field, the default followed by a newline and raw
+// without one so it can be piped straight into sudo -S.
+func writeItem(w io.Writer, format string, item onepass.Item, field string) error {
+	switch format {
+	case outputDefault, outputRaw:
+		value, err := item.GetField(field)
+		if err != nil {
+			return err
+		}
+
+		if format == outputDefault {
+			value += "\n"
+		}
+
+		_, err = io.WriteString(w, value)
+		return err
+	}
+
+	credentials, err := NewCredentials(item)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case outputJSON:
+		return json.NewEncoder(w).Encode(credentials)
+
+	case outputEnv:
+		// Quoted like export, so a file of them can be sourced with set -a
+		for _, pair := range credentials.env() {
+			if _, err := fmt.Fprintf(w, "%s=%s\n", pair[0], shellQuote(pair[1])); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case outputExport:
+		for _, pair := range credentials.env() {
+			if _, err := fmt.Fprintf(w, "export %s=%s\n", pair[0], shellQuote(pair[1])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// shellQuote single quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Output", func() {
+	var item onepass.Item
+
+	BeforeEach(func() {
+		item = onepass.LoginItem{
+			UUID:     "uuid",
+			Overview: map[string]interface{}{"title": "title", "url": "sudolikeaboss://local"},
+			SecureContents: onepass.LoginItemSecureContents{
+				Fields: []map[string]string{
+					{"designation": "username", "value": "user"},
+					{"designation": "password", "value": "it's secret"},
+				},
+			},
+		}
+	})
+
+	write := func(format string) string {
+		var buf bytes.Buffer
+		Expect(writeItem(&buf, format, item, "password")).To(Succeed())
+		return buf.String()
+	}
+
+	It("should print the field on a line", func() {
+		Expect(write(outputDefault)).To(Equal("it's secret\n"))
+	})
+
+	It("should print raw", func() {
+		Expect(write(outputRaw)).To(Equal("it's secret"))
+	})
+
+	It("should print json", func() {
+		Expect(write(outputJSON)).To(MatchJSON(`{
+			"uuid": "uuid",
+			"title": "title",
+			"url": "sudolikeaboss://local",
+			"username": "user",
+			"password": "it's secret"
+		}`))
+	})
+
+	It("should print env", func() {
+		Expect(write(outputEnv)).To(Equal("UUID='uuid'\nTITLE='title'\nURL='sudolikeaboss://local'\nUSERNAME='user'\nPASSWORD='it'\\''s secret'\n"))
+	})
+
+	It("should print shell exports", func() {
+		Expect(write(outputExport)).To(ContainSubstring("export PASSWORD='it'\\''s secret'\n"))
+	})
+
+	It("should leave the username of password items empty", func() {
+		item = onepass.PasswordItem{
+			UUID:           "uuid",
+			SecureContents: onepass.PasswordItemSecureContents{Password: "secret"},
+		}
+		Expect(write(outputEnv)).To(ContainSubstring("USERNAME=''\n"))
+	})
+
+	It("should reject unknown formats", func() {
+		Expect(writeItem(&bytes.Buffer{}, "xml", item, "password")).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// passphraseReader returns the passphrase protecting the pairing secret. It
+// comes from SUDOLIKEABOSS_PASSPHRASE or, failing that, is asked for once on
+// the controlling terminal, since stdin is the iTerm session when running as
+// a coprocess.
+func passphraseReader() func() ([]byte, error) {
+	var (
+		once       sync.Once
+		passphrase []byte
+		err        error
+	)
+
+	return func() ([]byte, error) {
+		once.Do(func() {
+			if env := os.Getenv("SUDOLIKEABOSS_PASSPHRASE"); env != "" {
+				passphrase = []byte(env)
+				return
+			}
+			passphrase, err = readPassphraseFromTTY()
+		})
+		return passphrase, err
+	}
+}
+
+func readPassphraseFromTTY() ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.New("no terminal to ask for the passphrase, set SUDOLIKEABOSS_PASSPHRASE")
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, "sudolikeaboss passphrase: ")
+	passphrase, err := terminal.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+
+	return passphrase, err
+}
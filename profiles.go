@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+)
+
+// listProfiles prints every profile with the settings it uses, falling back
+// to the regular configuration like --profile does.
+func listProfiles(conf *Configuration, w io.Writer) error {
+	profiles, err := onepass.LoadProfiles(conf.profilesPath())
+	if err != nil {
+		return cli.NewExitError(err.Error(), exitError)
+	}
+
+	if len(profiles.Profiles) == 0 {
+		fmt.Fprintln(w, "No profiles, add one with `sudolikeaboss profiles add NAME`.")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tWEBSOCKET URI\tORIGIN\tDEFAULT HOST\tREGISTERED")
+	for _, name := range profiles.Names() {
+		profile := profiles.Profiles[name]
+
+		oc := onepass.Configuration{
+			WebsocketURI:    conf.Websocket.URI,
+			WebsocketOrigin: conf.Websocket.Origin,
+			DefaultHost:     conf.DefaultHost,
+		}
+		profile.Apply(&oc)
+
+		registered := "no"
+		if profile.State != nil {
+			registered = "yes"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", name, oc.WebsocketURI, oc.WebsocketOrigin, oc.DefaultHost, registered)
+	}
+	return tw.Flush()
+}
+
+// addProfile creates the profile or updates the settings that are given,
+// keeping its registration.
+func addProfile(conf *Configuration, name string, settings onepass.Profile) error {
+	err := onepass.UpdateProfiles(conf.profilesPath(), func(profiles *onepass.Profiles) error {
+		profile, ok := profiles.Profiles[name]
+		if !ok {
+			profile = &onepass.Profile{}
+			profiles.Profiles[name] = profile
+		}
+
+		if settings.WebsocketURI != "" {
+			profile.WebsocketURI = settings.WebsocketURI
+		}
+		if settings.WebsocketProtocol != "" {
+			profile.WebsocketProtocol = settings.WebsocketProtocol
+		}
+		if settings.WebsocketOrigin != "" {
+			profile.WebsocketOrigin = settings.WebsocketOrigin
+		}
+		if settings.DefaultHost != "" {
+			profile.DefaultHost = settings.DefaultHost
+		}
+		return nil
+	})
+	if err != nil {
+		return cli.NewExitError(err.Error(), exitError)
+	}
+	return nil
+}
+
+// removeProfile deletes a profile. Like unregister, it first forgets its
+// pairing, so a secret kept in the keyring doesn't outlive it.
+func removeProfile(conf *Configuration, name string, secretStore onepass.SecretStore) error {
+	err := onepass.ForgetState(onepass.NewProfileStateStore(conf.StateDirectory, name), secretStore)
+	if err != nil {
+		return cli.NewExitError(err.Error(), exitError)
+	}
+
+	err = onepass.UpdateProfiles(conf.profilesPath(), func(profiles *onepass.Profiles) error {
+		if _, ok := profiles.Profiles[name]; !ok {
+			return cli.NewExitError(fmt.Sprintf("unknown profile %q", name), exitUsage)
+		}
+		delete(profiles.Profiles, name)
+		return nil
+	})
+	if _, ok := err.(*cli.ExitError); ok {
+		return err
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), exitError)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+	"github.com/brycekahle/sudolikeaboss/onepass/fakehelper"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profiles", func() {
+	var (
+		work     *testHelper
+		personal *testHelper
+		conf     *Configuration
+	)
+
+	BeforeEach(func() {
+		work = startHelper(&fakehelper.Item{
+			Title:    "work",
+			URL:      "sudolikeaboss://work",
+			Password: "work-password",
+		})
+		personal = startHelper(&fakehelper.Item{
+			Title:    "personal",
+			URL:      "sudolikeaboss://local",
+			Password: "personal-password",
+		})
+
+		conf = &Configuration{
+			DefaultHost:    "sudolikeaboss://local",
+			StateDirectory: personal.StateDir,
+			SecretStorage:  onepass.PlaintextStorage,
+			StateStore:     "file",
+		}
+		conf.Websocket.URI = personal.URL()
+		conf.Websocket.Origin = "resource://onepassword-at-agilebits-dot-com"
+	})
+
+	AfterEach(func() {
+		work.Close()
+		personal.Close()
+	})
+
+	register := func(conf *Configuration) {
+		oc, err := conf.onepassConfiguration()
+		Expect(err).To(BeNil())
+
+		done := make(chan bool, 1)
+		captureStdout(func() { registerWithOnepassword(&oc, done) })
+		Expect(<-done).To(BeTrue())
+	}
+
+	It("should use a separate pairing and helper per profile", func() {
+		Expect(addProfile(conf, "work", onepass.Profile{
+			WebsocketURI: work.URL(),
+			DefaultHost:  "sudolikeaboss://work",
+		})).To(Succeed())
+
+		register(conf)
+		workConf := *conf
+		workConf.Profile = "work"
+		register(&workConf)
+
+		oc, err := workConf.onepassConfiguration()
+		Expect(err).To(BeNil())
+		Expect(oc.WebsocketURI).To(Equal(work.URL()))
+		Expect(oc.DefaultHost).To(Equal("sudolikeaboss://work"))
+
+		done := make(chan bool, 1)
+		out := captureStdout(func() {
+			retrievePasswordFromOnepassword(context.Background(), &oc, showPopup, retrieveOptions{Field: "password", Output: outputRaw}, done)
+		})
+		Expect(out).To(Equal("work-password"))
+
+		workState, err := oc.StateStore.Load()
+		Expect(err).To(BeNil())
+		defaultState, err := onepass.NewFileStateStore(conf.StateDirectory).Load()
+		Expect(err).To(BeNil())
+		Expect(workState.ExtID).NotTo(Equal(defaultState.ExtID))
+		Expect(work.IsRegistered(workState.ExtID)).To(BeTrue())
+		Expect(personal.IsRegistered(workState.ExtID)).To(BeFalse())
+	})
+
+	It("should list, update and remove profiles", func() {
+		var out bytes.Buffer
+		Expect(listProfiles(conf, &out)).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("No profiles"))
+
+		Expect(addProfile(conf, "work", onepass.Profile{WebsocketURI: work.URL()})).To(Succeed())
+		Expect(addProfile(conf, "work", onepass.Profile{DefaultHost: "sudolikeaboss://work"})).To(Succeed())
+
+		out.Reset()
+		Expect(listProfiles(conf, &out)).To(Succeed())
+		Expect(out.String()).To(MatchRegexp(`work\s+` + work.URL() + `\s+\S+\s+sudolikeaboss://work\s+no`))
+
+		Expect(removeProfile(conf, "work", onepass.PlaintextSecretStore{})).To(Succeed())
+		Expect(removeProfile(conf, "work", onepass.PlaintextSecretStore{})).NotTo(Succeed())
+	})
+
+	It("should delete the keyring secret of a removed profile", func() {
+		keyring := onepass.NewMemoryKeyring()
+		Expect(keyring.Set(onepass.KeyringService, "work-ext-id", "s3cr3t")).To(Succeed())
+		Expect(onepass.NewProfileStateStore(conf.StateDirectory, "work").Save(&onepass.StateFileConfig{
+			ExtID:   "work-ext-id",
+			Storage: onepass.KeyringStorage,
+		})).To(Succeed())
+
+		Expect(removeProfile(conf, "work", &onepass.KeyringSecretStore{Keyring: keyring})).To(Succeed())
+
+		_, err := keyring.Get(onepass.KeyringService, "work-ext-id")
+		Expect(err).To(Equal(onepass.ErrKeyringItemNotFound))
+		profiles, err := onepass.LoadProfiles(conf.profilesPath())
+		Expect(err).To(BeNil())
+		Expect(profiles.Profiles).NotTo(HaveKey("work"))
+	})
+
+	It("should refuse profiles with the env state store", func() {
+		conf.Profile = "work"
+		conf.StateStore = "env"
+
+		_, err := conf.onepassConfiguration()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// promptPattern recognizes a password prompt at the end of the terminal
+// output. The named groups user and host fill in the terminal context.
+type promptPattern struct {
+	Name string
+	Re   *regexp.Regexp
+}
+
+var promptPatterns = []promptPattern{
+	{"sudo", regexp.MustCompile(`\[sudo\] password for (?P<user>[^\s:]+):\s*$`)},
+	{"ssh key", regexp.MustCompile(`Enter passphrase for key '(?P<key>[^']+)':\s*$`)},
+	{"ssh password", regexp.MustCompile(`(?P<user>[^\s@]+)@(?P<host>[^\s']+)'s password:\s*$`)},
+}
+
+// ansiEscape matches the terminal control sequences mixed into the output.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// maxPromptLength bounds how much of the current line is matched.
+const maxPromptLength = 1024
+
+// maxRawPromptLength bounds how much of the current line is kept before
+// stripping escapes, leaving room for the escapes around a prompt.
+const maxRawPromptLength = 4 * maxPromptLength
+
+// promptMatch is a prompt seen in the terminal output.
+type promptMatch struct {
+	Pattern string
+	Text    string
+	User    string
+	Host    string
+}
+
+// context returns what the prompt tells about the terminal.
+func (match *promptMatch) context() terminalContext {
+	return terminalContext{Prompt: match.Text, User: match.User, Host: strings.ToLower(match.Host)}
+}
+
+// matchPrompt checks whether line ends in a known prompt.
+func matchPrompt(line string) *promptMatch {
+	for _, pattern := range promptPatterns {
+		submatches := pattern.Re.FindStringSubmatch(line)
+		if submatches == nil {
+			continue
+		}
+
+		match := &promptMatch{Pattern: pattern.Name, Text: strings.TrimSpace(submatches[0])}
+		for i, name := range pattern.Re.SubexpNames() {
+			switch name {
+			case "user":
+				match.User = submatches[i]
+			case "host":
+				match.Host = submatches[i]
+			}
+		}
+		return match
+	}
+	return nil
+}
+
+// watchForPrompt reads terminal output from r until the current line ends in
+// a known prompt. Prompts aren't followed by a newline, so only the text after
+// the last one is matched. It returns nil when r ends without a prompt.
+func watchForPrompt(r io.Reader) (*promptMatch, error) {
+	var tail string
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			tail += string(buf[:n])
+			if i := strings.LastIndexAny(tail, "\r\n"); i >= 0 {
+				tail = tail[i+1:]
+			}
+			if len(tail) > maxRawPromptLength {
+				tail = tail[len(tail)-maxRawPromptLength:]
+			}
+
+			// Escapes can be split over reads, so they are stripped from
+			// the whole tail rather than from each read
+			line := ansiEscape.ReplaceAllString(tail, "")
+			if len(line) > maxPromptLength {
+				line = line[len(line)-maxPromptLength:]
+			}
+
+			if match := matchPrompt(line); match != nil {
+				return match, nil
+			}
+		}
+
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// runSudolikeabossWatch waits for a prompt on stdin, as sent to iTerm
+// coprocesses, before showing the popup for it.
+func runSudolikeabossWatch(options retrieveOptions) {
+	match, err := watchForPrompt(os.Stdin)
+	if err != nil {
+		fail(err)
+	}
+	if match == nil {
+		log.Info("No prompt seen before the end of the input")
+		os.Exit(0)
+	}
+
+	log.Infof("Saw a %s prompt: %q", match.Pattern, match.Text)
+	promptContext = match.context()
+
+	runSudolikeaboss(showPopup, options)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Prompt", func() {
+	It("should recognize sudo, ssh key and ssh password prompts", func() {
+		match := matchPrompt("[sudo] password for deploy: ")
+		Expect(match.Pattern).To(Equal("sudo"))
+		Expect(match.User).To(Equal("deploy"))
+
+		match = matchPrompt("Enter passphrase for key '/Users/me/.ssh/id_ed25519': ")
+		Expect(match.Pattern).To(Equal("ssh key"))
+
+		match = matchPrompt("admin@Prod-DB1's password: ")
+		Expect(match.Pattern).To(Equal("ssh password"))
+		Expect(match.context()).To(Equal(terminalContext{
+			Prompt: "admin@Prod-DB1's password:",
+			User:   "admin",
+			Host:   "prod-db1",
+		}))
+
+		Expect(matchPrompt("$ ls")).To(BeNil())
+	})
+
+	It("should only fire on a prompt at the end of the output", func() {
+		output := "$ cat notes\nremember the [sudo] password for deploy: is in 1Password\n$ "
+		Expect(watchForPrompt(strings.NewReader(output))).To(BeNil())
+	})
+
+	It("should find a prompt split over reads and wrapped in escapes", func() {
+		r, w := io.Pipe()
+		go func() {
+			defer GinkgoRecover()
+			for _, chunk := range []string{"$ sudo -i\r\n", "\x1b[1m[sudo] pass", "word for \x1b[0", "mdeploy: "} {
+				_, err := w.Write([]byte(chunk))
+				Expect(err).To(BeNil())
+			}
+		}()
+
+		match, err := watchForPrompt(r)
+		Expect(err).To(BeNil())
+		Expect(match.Text).To(Equal("[sudo] password for deploy:"))
+		Expect(match.User).To(Equal("deploy"))
+		w.Close()
+	})
+})
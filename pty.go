@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"syscall"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// maxInjectedSize bounds what a single connection can type into the shell.
+const maxInjectedSize = 64 << 10
+
+func ioctl(fd uintptr, request uintptr, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// copyWindowSize makes the pseudo terminal as large as ours.
+func copyWindowSize(from *os.File, to *os.File) error {
+	var size struct {
+		Rows, Cols, X, Y uint16
+	}
+
+	err := ioctl(from.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+	if err != nil {
+		return err
+	}
+	return ioctl(to.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&size)))
+}
+
+// runShell runs args, the user's shell if empty, in a pseudo terminal that
+// mirrors ours. Anything written to the socket named in its environment is
+// typed into it, which is how the pty sink delivers secrets. It returns the
+// exit code of the shell.
+func runShell(args []string) (int, error) {
+	if len(args) == 0 {
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		args = []string{shell}
+	}
+
+	master, slavePath, err := openPTY()
+	if err != nil {
+		return 0, err
+	}
+	defer master.Close()
+
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	// The socket lives in a directory only we can enter.
+	socketDir, err := ioutil.TempDir("", "sudolikeaboss")
+	if err != nil {
+		slave.Close()
+		return 0, err
+	}
+	defer os.RemoveAll(socketDir)
+
+	socket := path.Join(socketDir, "pty.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		slave.Close()
+		return 0, err
+	}
+	defer listener.Close()
+
+	go injectConnections(listener, master)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), ptySocketEnvironmentVariable+"="+socket)
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		_ = copyWindowSize(os.Stdin, master)
+
+		oldState, err := terminal.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			slave.Close()
+			return 0, err
+		}
+		defer terminal.Restore(int(os.Stdin.Fd()), oldState)
+
+		resized := make(chan os.Signal, 1)
+		signal.Notify(resized, syscall.SIGWINCH)
+		defer signal.Stop(resized)
+		go func() {
+			for range resized {
+				_ = copyWindowSize(os.Stdin, master)
+			}
+		}()
+	}
+
+	err = cmd.Start()
+	slave.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	go func() {
+		_, _ = io.Copy(master, os.Stdin)
+	}()
+	go func() {
+		_, _ = io.Copy(os.Stdout, master)
+	}()
+
+	return commandExitCode(cmd.Wait())
+}
+
+// injectConnections types whatever each connection sends into the pseudo
+// terminal.
+func injectConnections(listener net.Listener, master *os.File) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		secret, err := ioutil.ReadAll(io.LimitReader(conn, maxInjectedSize))
+		conn.Close()
+		if err != nil {
+			log.Debugf("Failed to read from the pty socket: %s", err)
+			continue
+		}
+
+		_, err = master.Write(secret)
+		if err != nil {
+			log.Debugf("Failed to type into the pty: %s", err)
+		}
+	}
+}
+
+func runSudolikeabossShell(args []string) {
+	code, err := runShell(args)
+	if err != nil {
+		fail(err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY opens a new pseudo terminal and returns its master and the path of
+// its slave.
+func openPTY() (*os.File, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = ioctl(master.Fd(), syscall.TIOCPTYGRANT, 0)
+	if err == nil {
+		err = ioctl(master.Fd(), syscall.TIOCPTYUNLK, 0)
+	}
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+
+	var name [128]byte
+	err = ioctl(master.Fd(), syscall.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0])))
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+
+	return master, string(name[:bytes.IndexByte(name[:], 0)]), nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// openPTY isn't implemented outside of Linux and macOS, so the shell command
+// fails there.
+func openPTY() (*os.File, string, error) {
+	return nil, "", errors.New("pseudo terminals are not supported on this platform")
+}
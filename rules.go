@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+	log "github.com/sirupsen/logrus"
+)
+
+// rulesFileNames are looked for like the config file unless rulesFile is set.
+var rulesFileNames = []string{"rules.toml", "rules.json", "rules.yaml", "rules.yml"}
+
+// urlRule picks the URL the popup is shown for. Every pattern that is set
+// has to match the terminal context; * and ? are wildcards.
+type urlRule struct {
+	Host   string `json:"host"`
+	User   string `json:"user"`
+	Cwd    string `json:"cwd"`
+	Prompt string `json:"prompt"`
+	TTY    string `json:"tty"`
+	URL    string `json:"url"`
+}
+
+// terminalContext is what the rules are matched against.
+type terminalContext struct {
+	// Host is the host named in the prompt, or else the target of the ssh
+	// command running in the terminal.
+	Host string
+	// User is the user named in the prompt.
+	User   string
+	Cwd    string
+	Prompt string
+	TTY    string
+}
+
+// promptContext is what is known from the prompt, given on the command line
+// or seen on stdin.
+var promptContext terminalContext
+
+func loadRules(rulesFile string) ([]urlRule, error) {
+	raw, err := decodeFile(rulesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Go through JSON to get the same field matching for every format.
+	rulesJSON, err := json.Marshal(stringKeys(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	var rules struct {
+		Rules []urlRule `json:"rules"`
+	}
+	err = json.Unmarshal(rulesJSON, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", rulesFile, err)
+	}
+
+	for i, rule := range rules.Rules {
+		if rule.URL == "" {
+			return nil, fmt.Errorf("%s: rule %d has no url", rulesFile, i+1)
+		}
+	}
+	return rules.Rules, nil
+}
+
+// stringKeys converts YAML mappings, which may have keys of any type, so
+// they can be marshaled to JSON.
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			converted[fmt.Sprint(key)] = stringKeys(value)
+		}
+		return converted
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = stringKeys(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = stringKeys(v[i])
+		}
+	case []map[string]interface{}:
+		for i := range v {
+			stringKeys(v[i])
+		}
+	}
+	return v
+}
+
+// matchURL returns the URL of the first rule matching ctx.
+func matchURL(rules []urlRule, ctx terminalContext) (string, bool) {
+	for _, rule := range rules {
+		if matchPattern(rule.Host, strings.ToLower(ctx.Host), true) &&
+			matchPattern(rule.User, ctx.User, false) &&
+			matchPattern(rule.Cwd, ctx.Cwd, false) &&
+			matchPattern(rule.Prompt, ctx.Prompt, false) &&
+			matchPattern(rule.TTY, ctx.TTY, false) {
+			return rule.URL, true
+		}
+	}
+	return "", false
+}
+
+// matchPattern reports whether value matches the glob pattern, where * also
+// matches slashes. An empty pattern matches anything.
+func matchPattern(pattern string, value string, ignoreCase bool) bool {
+	if pattern == "" {
+		return true
+	}
+
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+
+	matched, err := regexp.MatchString("^"+expr+"$", value)
+	return err == nil && matched
+}
+
+// currentContext inspects the terminal sudolikeaboss was started from to
+// complete known.
+func currentContext(known terminalContext, run onepass.CommandRunner) terminalContext {
+	ctx := known
+
+	cwd, err := os.Getwd()
+	if err == nil {
+		ctx.Cwd = cwd
+	}
+
+	tty, err := run("", "ps", "-o", "tty=", "-p", fmt.Sprint(os.Getpid()))
+	tty = strings.TrimSpace(tty)
+	if err != nil || tty == "" || strings.HasPrefix(tty, "?") {
+		log.Debugf("No controlling terminal: %v", err)
+		return ctx
+	}
+	ctx.TTY = "/dev/" + tty
+
+	processes, err := run("", "ps", "-o", "args=", "-t", tty)
+	if err != nil {
+		log.Debugf("Failed to list the processes on %s: %s", ctx.TTY, err)
+		return ctx
+	}
+	if ctx.Host == "" {
+		ctx.Host = sshTarget(processes)
+	}
+
+	return ctx
+}
+
+// sshOptionsWithArgument are the ssh options that take a value.
+const sshOptionsWithArgument = "BbcDEeFIiJLlmOopQRSWw"
+
+// sshTarget returns the host of the last ssh command in the ps output.
+func sshTarget(processes string) string {
+	host := ""
+	for _, line := range strings.Split(processes, "\n") {
+		args := strings.Fields(line)
+		if len(args) == 0 || path.Base(args[0]) != "ssh" {
+			continue
+		}
+
+		if destination := sshDestination(args[1:]); destination != "" {
+			host = destination
+		}
+	}
+	return host
+}
+
+func sshDestination(args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			if i+1 < len(args) {
+				return sshHost(args[i+1])
+			}
+			return ""
+		}
+
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return sshHost(arg)
+		}
+
+		// Options are bundled like -tA, the value of the last one may
+		// follow it directly or be the next argument.
+		for j := 1; j < len(arg); j++ {
+			if strings.IndexByte(sshOptionsWithArgument, arg[j]) >= 0 {
+				if j == len(arg)-1 {
+					i++
+				}
+				break
+			}
+		}
+	}
+	return ""
+}
+
+// sshHost strips the user and port from an ssh destination.
+func sshHost(destination string) string {
+	destination = strings.TrimPrefix(destination, "ssh://")
+	if at := strings.LastIndex(destination, "@"); at >= 0 {
+		destination = destination[at+1:]
+	}
+	if colon := strings.Index(destination, ":"); colon >= 0 {
+		destination = destination[:colon]
+	}
+	return strings.ToLower(destination)
+}
+
+// popupURL returns the URL of the rule matching the current terminal, or
+// defaultHost if there are no rules or none match.
+func (conf *Configuration) popupURL(defaultHost string, run onepass.CommandRunner) (string, error) {
+	rulesFile := conf.RulesFile
+	if rulesFile == "" {
+		var err error
+		rulesFile, err = findFile(conf.StateDirectory, rulesFileNames)
+		if err != nil || rulesFile == "" {
+			return defaultHost, err
+		}
+	}
+
+	rules, err := loadRules(rulesFile)
+	if err != nil {
+		return "", err
+	}
+
+	ctx := currentContext(promptContext, run)
+	url, ok := matchURL(rules, ctx)
+	if !ok {
+		log.Debugf("No rule in %s matches %+v", rulesFile, ctx)
+		return defaultHost, nil
+	}
+
+	log.Debugf("Showing the popup for %s, matching %+v", url, ctx)
+	return url, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rules", func() {
+	rules := []urlRule{
+		{Host: "prod-db*", URL: "sudolikeaboss://prod-db"},
+		{Prompt: "*[sudo] password*", Cwd: "/home/me/work/*", URL: "sudolikeaboss://work-sudo"},
+		{TTY: "/dev/ttys00?", URL: "sudolikeaboss://tty"},
+	}
+
+	It("should pick the first matching rule", func() {
+		url, ok := matchURL(rules, terminalContext{Host: "PROD-db1.example.com", TTY: "/dev/ttys001"})
+		Expect(ok).To(BeTrue())
+		Expect(url).To(Equal("sudolikeaboss://prod-db"))
+
+		url, ok = matchURL(rules, terminalContext{Cwd: "/home/me/work/project", Prompt: "[sudo] password for me: "})
+		Expect(ok).To(BeTrue())
+		Expect(url).To(Equal("sudolikeaboss://work-sudo"))
+
+		url, ok = matchURL(rules, terminalContext{TTY: "/dev/ttys003"})
+		Expect(ok).To(BeTrue())
+		Expect(url).To(Equal("sudolikeaboss://tty"))
+	})
+
+	It("should need every pattern of a rule to match", func() {
+		_, ok := matchURL(rules, terminalContext{Cwd: "/home/me/work/project", Prompt: "Password: ", TTY: "/dev/pts/1"})
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should find the ssh target in the terminal", func() {
+		Expect(sshTarget("-zsh\nssh -p 2222 -i ~/.ssh/id admin@Prod-DB1\n")).To(Equal("prod-db1"))
+		Expect(sshTarget("/usr/bin/ssh -tA -o ProxyJump=bastion ssh://me@web:2222 uptime\n")).To(Equal("web"))
+		Expect(sshTarget("/usr/bin/ssh -lme -- db2\n")).To(Equal("db2"))
+		Expect(sshTarget("-bash\nvim ssh\n")).To(BeEmpty())
+	})
+
+	It("should inspect the terminal with ps", func() {
+		run := func(stdin string, name string, args ...string) (string, error) {
+			Expect(name).To(Equal("ps"))
+			if args[1] == "tty=" {
+				return "ttys004\n", nil
+			}
+			Expect(args).To(Equal([]string{"-o", "args=", "-t", "ttys004"}))
+			return "-zsh\nssh prod-db1\n", nil
+		}
+
+		ctx := currentContext(terminalContext{Prompt: "Password:"}, run)
+		Expect(ctx.TTY).To(Equal("/dev/ttys004"))
+		Expect(ctx.Host).To(Equal("prod-db1"))
+		Expect(ctx.Prompt).To(Equal("Password:"))
+		Expect(ctx.Cwd).NotTo(BeEmpty())
+	})
+
+	Describe("rules file", func() {
+		var (
+			xdgDir string
+			conf   *Configuration
+			err    error
+		)
+
+		noTerminal := func(stdin string, name string, args ...string) (string, error) {
+			return "", errors.New("no terminal")
+		}
+
+		BeforeEach(func() {
+			xdgDir, err = ioutil.TempDir("", "sudolikeaboss-xdg")
+			Expect(err).To(BeNil())
+			os.Setenv("XDG_CONFIG_HOME", xdgDir)
+			Expect(os.MkdirAll(path.Join(xdgDir, "sudolikeaboss"), 0700)).To(Succeed())
+
+			conf = &Configuration{StateDirectory: path.Join(xdgDir, "state")}
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("XDG_CONFIG_HOME")
+			promptContext = terminalContext{}
+			Expect(os.RemoveAll(xdgDir)).To(Succeed())
+		})
+
+		writeRules := func(name string, content string) string {
+			rulesFile := path.Join(xdgDir, "sudolikeaboss", name)
+			Expect(ioutil.WriteFile(rulesFile, []byte(content), 0600)).To(Succeed())
+			return rulesFile
+		}
+
+		It("should use the default host without rules", func() {
+			Expect(conf.popupURL("sudolikeaboss://local", noTerminal)).To(Equal("sudolikeaboss://local"))
+		})
+
+		It("should load rules from TOML", func() {
+			writeRules("rules.toml", `
+[[rules]]
+prompt = "*sudo*"
+url = "sudolikeaboss://sudo"
+
+[[rules]]
+url = "sudolikeaboss://fallback"
+`)
+			promptContext.Prompt = "[sudo] password for me:"
+			Expect(conf.popupURL("sudolikeaboss://local", noTerminal)).To(Equal("sudolikeaboss://sudo"))
+
+			promptContext.Prompt = "Password:"
+			Expect(conf.popupURL("sudolikeaboss://local", noTerminal)).To(Equal("sudolikeaboss://fallback"))
+		})
+
+		It("should load rules from YAML given in the configuration", func() {
+			conf.RulesFile = writeRules("hosts.yaml", "rules:\n  - prompt: '*gpg*'\n    url: sudolikeaboss://gpg\n")
+
+			promptContext.Prompt = "Enter gpg passphrase"
+			Expect(conf.popupURL("sudolikeaboss://local", noTerminal)).To(Equal("sudolikeaboss://gpg"))
+
+			promptContext.Prompt = "Password:"
+			Expect(conf.popupURL("sudolikeaboss://local", noTerminal)).To(Equal("sudolikeaboss://local"))
+		})
+
+		It("should reject rules without a url", func() {
+			writeRules("rules.json", `{"rules": [{"host": "db"}]}`)
+
+			_, err := conf.popupURL("sudolikeaboss://local", noTerminal)
+			Expect(err).To(MatchError(ContainSubstring("rule 1 has no url")))
+		})
+	})
+})
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+)
+
+// Sinks accepted by --sink.
+const (
+	sinkStdout    = "stdout"
+	sinkTmux      = "tmux"
+	sinkScreen    = "screen"
+	sinkPTY       = "pty"
+	sinkClipboard = "clipboard"
+)
+
+var sinkNames = []string{sinkStdout, sinkTmux, sinkScreen, sinkPTY, sinkClipboard}
+
+// ptySocketEnvironmentVariable tells the sessions started by `sudolikeaboss
+// shell` where to deliver secrets.
+const ptySocketEnvironmentVariable = "SUDOLIKEABOSS_PTY_SOCKET"
+
+// sink delivers the retrieved secret to the terminal.
+type sink interface {
+	Deliver(secret string) error
+}
+
+// waitingSink has more to do once the secret is delivered, like clearing the
+// clipboard. Wait blocks until that is done.
+type waitingSink interface {
+	sink
+	Wait() error
+}
+
+func newSink(name string, clearAfter time.Duration) (sink, error) {
+	switch name {
+	case "", sinkStdout:
+		return &stdoutSink{W: os.Stdout}, nil
+	case sinkTmux:
+		return &tmuxSink{Tmux: execTmux{}}, nil
+	case sinkScreen:
+		return &screenSink{Run: onepass.RunCommand}, nil
+	case sinkPTY:
+		return &ptySink{Socket: os.Getenv(ptySocketEnvironmentVariable)}, nil
+	case sinkClipboard:
+		return &clipboardSink{Clipboard: systemClipboard(), ClearAfter: clearAfter}, nil
+	}
+
+	return nil, fmt.Errorf("unknown sink %q, expected one of %s", name, strings.Join(sinkNames, ", "))
+}
+
+// stdoutSink writes the secret to stdout, which iTerm types into the session
+// for coprocesses.
+type stdoutSink struct {
+	W io.Writer
+}
+
+func (sink *stdoutSink) Deliver(secret string) error {
+	_, err := io.WriteString(sink.W, secret)
+	return err
+}
+
+// tmuxSink types the secret into the current tmux pane.
+type tmuxSink struct {
+	Tmux    tmuxRunner
+	Options tmuxOptions
+}
+
+func (sink *tmuxSink) Deliver(secret string) error {
+	return typeIntoTmux(sink.Tmux, sink.Options, secret)
+}
+
+// screenSink types the secret into the current GNU screen window with stuff.
+// The secret is passed as an argument and visible to ps while screen runs.
+type screenSink struct {
+	Run onepass.CommandRunner
+}
+
+func (sink *screenSink) Deliver(secret string) error {
+	if os.Getenv("STY") == "" {
+		return errors.New("not running inside GNU screen")
+	}
+
+	_, err := sink.Run("", "screen", "-X", "stuff", secret)
+	return err
+}
+
+// ptySink hands the secret to the `sudolikeaboss shell` wrapping this
+// terminal, which types it into the shell.
+type ptySink struct {
+	Socket string
+}
+
+func (sink *ptySink) Deliver(secret string) error {
+	if sink.Socket == "" {
+		return fmt.Errorf("not running inside `sudolikeaboss shell`, %s is not set", ptySocketEnvironmentVariable)
+	}
+
+	conn, err := net.Dial("unix", sink.Socket)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(conn, secret)
+	closeErr := conn.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// clipboard copies with one command and pastes with another, reading and
+// writing the content on stdio.
+type clipboard struct {
+	Copy  []string
+	Paste []string
+	// RunCopy runs Copy, which only reads stdin.
+	RunCopy onepass.CommandRunner
+	// Run runs Paste.
+	Run onepass.CommandRunner
+}
+
+func systemClipboard() *clipboard {
+	clipboard := &clipboard{
+		Copy:    []string{"xclip", "-selection", "clipboard"},
+		Paste:   []string{"xclip", "-selection", "clipboard", "-o"},
+		RunCopy: runCopy,
+		Run:     onepass.RunCommand,
+	}
+
+	switch {
+	case runtime.GOOS == "darwin":
+		clipboard.Copy, clipboard.Paste = []string{"pbcopy"}, []string{"pbpaste"}
+	case os.Getenv("WAYLAND_DISPLAY") != "":
+		clipboard.Copy, clipboard.Paste = []string{"wl-copy"}, []string{"wl-paste", "-n"}
+	}
+	return clipboard
+}
+
+func (clipboard *clipboard) Set(content string) error {
+	_, err := clipboard.RunCopy(content, clipboard.Copy[0], clipboard.Copy[1:]...)
+	return err
+}
+
+func (clipboard *clipboard) Get() (string, error) {
+	return clipboard.Run("", clipboard.Paste[0], clipboard.Paste[1:]...)
+}
+
+// clipboardSink copies the secret and clears the clipboard after ClearAfter,
+// unless something else was copied meanwhile. The process stays around until
+// then, see runSudolikeaboss.
+type clipboardSink struct {
+	Clipboard  *clipboard
+	ClearAfter time.Duration
+
+	secret string
+}
+
+// Deliver copies the secret without the newline the default output format
+// ends with, so pasting it doesn't also press enter.
+func (sink *clipboardSink) Deliver(secret string) error {
+	secret = strings.TrimSuffix(secret, "\n")
+	sink.secret = secret
+	return sink.Clipboard.Set(secret)
+}
+
+func (sink *clipboardSink) Wait() error {
+	if sink.ClearAfter <= 0 {
+		return nil
+	}
+	time.Sleep(sink.ClearAfter)
+
+	content, err := sink.Clipboard.Get()
+	if err != nil {
+		return err
+	}
+	if content != sink.secret {
+		return nil
+	}
+	return sink.Clipboard.Set("")
+}
+
+// runCopy runs a copy command with stdin. xclip and wl-copy leave a process
+// behind that serves the clipboard and inherits stdout and stderr, so they
+// aren't read through pipes, which would wait for that process to exit.
+func runCopy(stdin string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", name, err)
+	}
+	return "", nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeClipboard keeps the content in memory.
+type fakeClipboard struct {
+	content string
+	copies  int
+}
+
+func (fake *fakeClipboard) Run(stdin string, name string, args ...string) (string, error) {
+	if name == "copy" {
+		fake.content = stdin
+		fake.copies++
+		return "", nil
+	}
+	return fake.content, nil
+}
+
+var _ = Describe("Sinks", func() {
+	It("should write to stdout", func() {
+		var out bytes.Buffer
+		Expect((&stdoutSink{W: &out}).Deliver("s3cr3t")).To(Succeed())
+		Expect(out.String()).To(Equal("s3cr3t"))
+	})
+
+	It("should reject unknown sinks", func() {
+		_, err := newSink("printer", 0)
+		Expect(err).To(MatchError(ContainSubstring(`unknown sink "printer"`)))
+	})
+
+	It("should stuff the secret into screen", func() {
+		defer os.Setenv("STY", os.Getenv("STY"))
+		os.Setenv("STY", "1234.pts-0.host")
+
+		var commands []string
+		sink := &screenSink{Run: func(stdin string, name string, args ...string) (string, error) {
+			commands = append(commands, name+" "+strings.Join(args, " "))
+			return "", nil
+		}}
+
+		Expect(sink.Deliver("s3cr3t")).To(Succeed())
+		Expect(commands).To(Equal([]string{"screen -X stuff s3cr3t"}))
+	})
+
+	It("should type into tmux", func() {
+		tmux := &fakeTmux{}
+		Expect((&tmuxSink{Tmux: tmux, Options: tmuxOptions{SendKeys: true}}).Deliver("s3cr3t")).To(Succeed())
+		Expect(tmux.commands).To(Equal([]string{"send-keys -l -- s3cr3t"}))
+	})
+
+	It("should hand the secret to the pty socket", func() {
+		dir, err := ioutil.TempDir("", "sudolikeaboss-test")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		socket := path.Join(dir, "pty.sock")
+		listener, err := net.Listen("unix", socket)
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+
+		received := make(chan string, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			data, _ := ioutil.ReadAll(conn)
+			conn.Close()
+			received <- string(data)
+		}()
+
+		Expect((&ptySink{Socket: socket}).Deliver("s3cr3t")).To(Succeed())
+		Eventually(received).Should(Receive(Equal("s3cr3t")))
+	})
+
+	It("should fail outside of sudolikeaboss shell", func() {
+		err := (&ptySink{}).Deliver("s3cr3t")
+		Expect(err).To(MatchError(ContainSubstring(ptySocketEnvironmentVariable)))
+	})
+
+	Describe("clipboard", func() {
+		var (
+			fake *fakeClipboard
+			sink *clipboardSink
+		)
+
+		BeforeEach(func() {
+			fake = &fakeClipboard{}
+			sink = &clipboardSink{
+				Clipboard:  &clipboard{Copy: []string{"copy"}, Paste: []string{"paste"}, RunCopy: fake.Run, Run: fake.Run},
+				ClearAfter: time.Millisecond,
+			}
+		})
+
+		It("should clear the secret after a while", func() {
+			Expect(sink.Deliver("s3cr3t")).To(Succeed())
+			Expect(fake.content).To(Equal("s3cr3t"))
+
+			Expect(sink.Wait()).To(Succeed())
+			Expect(fake.content).To(BeEmpty())
+		})
+
+		It("should copy the secret without the newline of the default output", func() {
+			Expect(sink.Deliver("s3cr3t\n")).To(Succeed())
+			Expect(fake.content).To(Equal("s3cr3t"))
+
+			Expect(sink.Wait()).To(Succeed())
+			Expect(fake.content).To(BeEmpty())
+		})
+
+		It("should leave something copied meanwhile", func() {
+			Expect(sink.Deliver("s3cr3t")).To(Succeed())
+			fake.content = "something else"
+
+			Expect(sink.Wait()).To(Succeed())
+			Expect(fake.content).To(Equal("something else"))
+			Expect(fake.copies).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+)
+
+// stateLocation describes where store keeps the pairing state.
+func stateLocation(store onepass.StateStore) string {
+	switch store := store.(type) {
+	case *onepass.FileStateStore:
+		return store.Path
+	case *onepass.ProfileStateStore:
+		return fmt.Sprintf("%s (profile %s)", store.Path, store.Name)
+	case *onepass.EnvStateStore:
+		return "$" + store.Name
+	}
+	return fmt.Sprintf("%T", store)
+}
+
+// printStatus reports the saved registration and what the helper, described
+// by helper, makes of it. It only reads the state, so it neither registers
+// nor migrates it.
+func printStatus(ctx context.Context, w io.Writer, configuration *onepass.Configuration, helper string) error {
+	state, err := configuration.StateStore.Load()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "State:   %s\n", stateLocation(configuration.StateStore))
+
+	extID := ""
+	if state == nil {
+		fmt.Fprintln(w, "ExtID:   none, run `sudolikeaboss register`")
+	} else {
+		extID = state.ExtID
+		fmt.Fprintf(w, "ExtID:   %s\n", state.ExtID)
+		fmt.Fprintf(w, "Secret:  %s\n", storageOrPlaintext(state.Storage))
+	}
+
+	action, err := onepass.Probe(ctx, configuration, extID)
+	if errors.Is(err, onepass.ErrHelperUnreachable) {
+		fmt.Fprintf(w, "Helper:  %s is unreachable: %s\n", helper, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Helper:  %s is reachable\n", helper)
+
+	if state == nil {
+		return nil
+	}
+
+	switch action {
+	case "authBegin":
+		fmt.Fprintln(w, "Hello:   authBegin, the helper knows this registration")
+	case "authNew":
+		fmt.Fprintln(w, "Hello:   authNew, the helper doesn't know this registration, run `sudolikeaboss register`")
+	}
+	return nil
+}
+
+// helperName describes the helper the configuration connects to.
+func (conf *Configuration) helperName() string {
+	if conf.Transport == "native" {
+		return conf.NativeHelper
+	}
+	return conf.Websocket.URI
+}
+
+func storageOrPlaintext(storage string) string {
+	if storage == "" {
+		return onepass.PlaintextStorage
+	}
+	return storage
+}
+
+// unregister wipes the saved registration, including a secret kept in the
+// keyring. The helper protocol has no command to revoke a registration, so
+// it has to be removed in 1Password as well.
+func unregister(w io.Writer, configuration *onepass.Configuration) error {
+	state, err := configuration.StateStore.Load()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		fmt.Fprintln(w, "sudolikeaboss is not registered.")
+		return nil
+	}
+
+	err = onepass.ForgetState(configuration.StateStore, configuration.SecretStore)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "sudolikeaboss is unregistered here. Revoke the registration %s in 1Password too.\n", state.ExtID)
+	return nil
+}
+
+func runSudolikeabossStatus() {
+	conf := LoadConfiguration()
+	oc, err := conf.helperConfiguration()
+	if err != nil {
+		fail(err)
+	}
+
+	ctx, cancel := conf.timeoutContext()
+	defer cancel()
+
+	err = printStatus(ctx, os.Stdout, &oc, conf.helperName())
+	if err != nil {
+		fail(err)
+	}
+	os.Exit(0)
+}
+
+func runSudolikeabossUnregistration() {
+	conf := LoadConfiguration()
+	oc, err := conf.helperConfiguration()
+	if err != nil {
+		fail(err)
+	}
+
+	err = unregister(os.Stdout, &oc)
+	if err != nil {
+		fail(err)
+	}
+	os.Exit(0)
+}
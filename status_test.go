@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+	"github.com/brycekahle/sudolikeaboss/onepass/fakehelper"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Status", func() {
+	var (
+		helper *testHelper
+		conf   *onepass.Configuration
+		out    *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		helper = startHelper()
+		conf = helper.Config()
+		conf.StateStore = onepass.NewFileStateStore(helper.StateDir)
+		out = &bytes.Buffer{}
+	})
+
+	AfterEach(func() {
+		helper.Close()
+	})
+
+	It("should not register when showing the status", func() {
+		Expect(printStatus(context.Background(), out, conf, conf.WebsocketURI)).To(Succeed())
+
+		Expect(out.String()).To(ContainSubstring("ExtID:   none"))
+		Expect(out.String()).To(ContainSubstring(helper.URL() + " is reachable"))
+		Expect(conf.StateStore.Load()).To(BeNil())
+	})
+
+	It("should show a registration the helper knows", func() {
+		state := helper.register()
+
+		Expect(printStatus(context.Background(), out, conf, conf.WebsocketURI)).To(Succeed())
+
+		Expect(out.String()).To(ContainSubstring("State:   " + conf.StateStore.(*onepass.FileStateStore).Path))
+		Expect(out.String()).To(ContainSubstring("ExtID:   " + state.ExtID))
+		Expect(out.String()).To(ContainSubstring("authBegin"))
+	})
+
+	It("should show a registration the helper forgot", func() {
+		helper.register()
+		Expect(helper.Helper.Close()).To(Succeed())
+		helper.Helper = fakehelper.NewHelper(nil)
+		Expect(helper.Start()).To(Succeed())
+		conf.WebsocketURI = helper.URL()
+
+		Expect(printStatus(context.Background(), out, conf, conf.WebsocketURI)).To(Succeed())
+
+		Expect(out.String()).To(ContainSubstring("authNew"))
+	})
+
+	It("should show an unreachable helper", func() {
+		helper.register()
+		Expect(helper.Helper.Close()).To(Succeed())
+
+		Expect(printStatus(context.Background(), out, conf, conf.WebsocketURI)).To(Succeed())
+
+		Expect(out.String()).To(ContainSubstring("is unreachable"))
+	})
+
+	It("should wipe the registration and say to revoke it in 1Password", func() {
+		state := helper.register()
+
+		Expect(unregister(out, conf)).To(Succeed())
+
+		Expect(out.String()).To(ContainSubstring("Revoke the registration " + state.ExtID + " in 1Password too"))
+		Expect(conf.StateStore.Load()).To(BeNil())
+	})
+
+	It("should wipe the registration when the helper is unreachable", func() {
+		helper.register()
+		Expect(helper.Helper.Close()).To(Succeed())
+
+		Expect(unregister(out, conf)).To(Succeed())
+
+		Expect(conf.StateStore.Load()).To(BeNil())
+	})
+})
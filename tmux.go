@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/brycekahle/sudolikeaboss/onepass"
+)
+
+// tmuxRunner runs tmux commands, so the integration can be tested without
+// tmux.
+type tmuxRunner interface {
+	// Run runs tmux with args, feeding it stdin, and returns its output.
+	Run(stdin string, args ...string) (string, error)
+}
+
+// execTmux runs the tmux binary found in the PATH.
+type execTmux struct{}
+
+func (execTmux) Run(stdin string, args ...string) (string, error) {
+	return onepass.RunCommand(stdin, "tmux", args...)
+}
+
+// tmuxOptions says where and how the secret is typed.
+type tmuxOptions struct {
+	// Pane is the target pane, the current one if empty.
+	Pane string
+	// SendKeys types the secret with send-keys instead of a paste buffer.
+	// It is passed as an argument and visible to ps while tmux runs.
+	SendKeys bool
+	// Enter presses enter afterwards.
+	Enter bool
+}
+
+func (options tmuxOptions) target() []string {
+	if options.Pane == "" {
+		return nil
+	}
+	return []string{"-t", options.Pane}
+}
+
+// typeIntoTmux types value into the pane. By default the value is loaded into
+// a paste buffer through stdin and the buffer is deleted once pasted.
+func typeIntoTmux(tmux tmuxRunner, options tmuxOptions, value string) error {
+	if options.SendKeys {
+		args := append([]string{"send-keys"}, options.target()...)
+		_, err := tmux.Run("", append(args, "-l", "--", value)...)
+		if err != nil {
+			return err
+		}
+	} else {
+		buffer := fmt.Sprintf("sudolikeaboss-%d", os.Getpid())
+
+		_, err := tmux.Run(value, "load-buffer", "-b", buffer, "-")
+		if err != nil {
+			return err
+		}
+
+		args := append([]string{"paste-buffer", "-d", "-b", buffer}, options.target()...)
+		_, err = tmux.Run("", args...)
+		if err != nil {
+			// -d only deletes the buffer when pasting succeeds
+			_, _ = tmux.Run("", "delete-buffer", "-b", buffer)
+			return err
+		}
+	}
+
+	if options.Enter {
+		args := append([]string{"send-keys"}, options.target()...)
+		_, err := tmux.Run("", append(args, "Enter")...)
+		return err
+	}
+	return nil
+}
+
+// resolveValue looks up a single field of the referenced item and sends it on
+// done.
+func resolveValue(ctx context.Context, configuration *onepass.Configuration, ref *itemReference, field string, done chan string) {
+	client, err := onepass.NewClientWithConfig(configuration)
+	if err != nil {
+		fail(err)
+	}
+	defer client.Close()
+
+	_, err = client.Authenticate(ctx, false)
+	if err != nil {
+		fail(err)
+	}
+
+	response, err := ref.fetch()(ctx, client)
+	if err != nil {
+		fail(err)
+	}
+
+	value, err := response.GetField(field)
+	if err != nil {
+		fail(err)
+	}
+
+	done <- value
+}
+
+func runSudolikeabossTmux(ref *itemReference, field string, options tmuxOptions) {
+	done := make(chan string, 1)
+
+	conf := LoadConfiguration()
+	oc, err := conf.onepassConfiguration()
+	if err != nil {
+		fail(err)
+	}
+
+	ctx, cancel := conf.timeoutContext()
+	defer cancel()
+
+	go resolveValue(ctx, &oc, ref, field, done)
+
+	var value string
+	select {
+	case value = <-done:
+	case <-ctx.Done():
+		fail(onepass.ErrTimeout)
+	}
+
+	err = typeIntoTmux(execTmux{}, options, value)
+	if err != nil {
+		fail(err)
+	}
+	os.Exit(0)
+}
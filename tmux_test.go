@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brycekahle/sudolikeaboss/onepass/fakehelper"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeTmux records the tmux commands and fails the ones named in failing.
+type fakeTmux struct {
+	commands []string
+	stdin    []string
+	failing  string
+}
+
+func (tmux *fakeTmux) Run(stdin string, args ...string) (string, error) {
+	tmux.commands = append(tmux.commands, strings.Join(args, " "))
+	tmux.stdin = append(tmux.stdin, stdin)
+	if args[0] == tmux.failing {
+		return "", errors.New("no current client")
+	}
+	return "", nil
+}
+
+var _ = Describe("Tmux", func() {
+	var (
+		tmux   *fakeTmux
+		buffer string
+	)
+
+	BeforeEach(func() {
+		tmux = &fakeTmux{}
+		buffer = fmt.Sprintf("sudolikeaboss-%d", os.Getpid())
+	})
+
+	It("should paste the secret through a buffer that is deleted", func() {
+		Expect(typeIntoTmux(tmux, tmuxOptions{Pane: "%3", Enter: true}, "s3cr3t")).To(Succeed())
+
+		Expect(tmux.commands).To(Equal([]string{
+			"load-buffer -b " + buffer + " -",
+			"paste-buffer -d -b " + buffer + " -t %3",
+			"send-keys -t %3 Enter",
+		}))
+		Expect(tmux.stdin[0]).To(Equal("s3cr3t"))
+		for _, command := range tmux.commands {
+			Expect(command).NotTo(ContainSubstring("s3cr3t"))
+		}
+	})
+
+	It("should delete the buffer when pasting fails", func() {
+		tmux.failing = "paste-buffer"
+
+		Expect(typeIntoTmux(tmux, tmuxOptions{}, "s3cr3t")).NotTo(Succeed())
+
+		Expect(tmux.commands).To(Equal([]string{
+			"load-buffer -b " + buffer + " -",
+			"paste-buffer -d -b " + buffer,
+			"delete-buffer -b " + buffer,
+		}))
+	})
+
+	It("should type the secret with send-keys", func() {
+		Expect(typeIntoTmux(tmux, tmuxOptions{SendKeys: true}, "-s3cr3t")).To(Succeed())
+
+		Expect(tmux.commands).To(Equal([]string{"send-keys -l -- -s3cr3t"}))
+	})
+
+	It("should resolve the field to type", func() {
+		helper := startRegisteredHelper(&fakehelper.Item{
+			Title:    "db",
+			Username: "admin",
+			Password: "s3cr3t",
+		})
+		defer helper.Close()
+
+		done := make(chan string, 1)
+		resolveValue(context.Background(), helper.Config(), &itemReference{Popup: true}, "username", done)
+		Expect(<-done).To(Equal("admin"))
+	})
+})
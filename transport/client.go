@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/brycekahle/sudolikeaboss/nativemessaging"
+)
+
+// Client is how the onepass client talked to the helper before Transport,
+// like websocketclient.Client does. Send is given each message as a []byte
+// and Receive a *[]byte to fill in.
+type Client interface {
+	Connect() error
+	Receive(v interface{}) error
+	Send(v interface{}) error
+}
+
+type clientTransport struct {
+	client Client
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// FromClient dials by connecting client. Its calls can't be interrupted, so
+// when ctx is done first they are abandoned and the transport is closed, as
+// the abandoned call would take the next message. Close closes client if it
+// has a Close method.
+func FromClient(client Client) Dialer {
+	return func(ctx context.Context) (Transport, error) {
+		transport := &clientTransport{client: client, closed: make(chan struct{})}
+
+		err := transport.call(ctx, client.Connect)
+		if err != nil {
+			return nil, err
+		}
+		return transport, nil
+	}
+}
+
+// DialNativeMessaging spawns the native messaging helper command.
+func DialNativeMessaging(command string, args ...string) Dialer {
+	return FromClient(nativemessaging.NewClient(command, args...))
+}
+
+func (transport *clientTransport) call(ctx context.Context, f func() error) error {
+	select {
+	case <-transport.closed:
+		return ErrClosed
+	default:
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		return f()
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- f()
+	}()
+
+	select {
+	case err = <-result:
+		return err
+	case <-transport.closed:
+		return ErrClosed
+	case <-ctx.Done():
+		_ = transport.Close()
+		return ctx.Err()
+	}
+}
+
+func (transport *clientTransport) Send(ctx context.Context, message []byte) error {
+	return transport.call(ctx, func() error {
+		return transport.client.Send(message)
+	})
+}
+
+func (transport *clientTransport) Receive(ctx context.Context) ([]byte, error) {
+	var message []byte
+	err := transport.call(ctx, func() error {
+		return transport.client.Receive(&message)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (transport *clientTransport) Close() error {
+	var err error
+	transport.closeOnce.Do(func() {
+		close(transport.closed)
+		if closer, ok := transport.client.(io.Closer); ok {
+			err = closer.Close()
+		}
+	})
+	return err
+}
@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"context"
+	"time"
+)
+
+// Message types of gorilla-style websockets, as in RFC 6455.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+// GorillaConn is the part of a github.com/gorilla/websocket connection, or
+// one with the same API, that is needed to carry messages.
+type GorillaConn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+type gorillaTransport struct {
+	conn        GorillaConn
+	messageType int
+}
+
+// NewGorilla adapts a gorilla-style websocket connection, sending messages
+// as messageType frames.
+func NewGorilla(conn GorillaConn, messageType int) Transport {
+	return &gorillaTransport{conn: conn, messageType: messageType}
+}
+
+func (transport *gorillaTransport) Send(ctx context.Context, message []byte) error {
+	done, err := interruptible(ctx, transport.conn.SetWriteDeadline)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	return contextError(ctx, transport.conn.WriteMessage(transport.messageType, message))
+}
+
+func (transport *gorillaTransport) Receive(ctx context.Context) ([]byte, error) {
+	done, err := interruptible(ctx, transport.conn.SetReadDeadline)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	_, message, err := transport.conn.ReadMessage()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return message, nil
+}
+
+func (transport *gorillaTransport) Close() error {
+	return transport.conn.Close()
+}
@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"context"
+	"sync"
+)
+
+// pipe is shared by both ends of a Pipe.
+type pipe struct {
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+type pipeEnd struct {
+	pipe *pipe
+	in   <-chan []byte
+	out  chan<- []byte
+}
+
+// Pipe returns the two ends of an in-memory transport, for tests. Like
+// net.Pipe each Send waits for the Receive on the other end, and closing
+// either end closes both.
+func Pipe() (Transport, Transport) {
+	shared := &pipe{closed: make(chan struct{})}
+	aToB := make(chan []byte)
+	bToA := make(chan []byte)
+
+	return &pipeEnd{pipe: shared, in: bToA, out: aToB},
+		&pipeEnd{pipe: shared, in: aToB, out: bToA}
+}
+
+// PipeDialer returns a Dialer that hands one end of a new Pipe to serve,
+// which runs on its own goroutine, and the other to the caller.
+func PipeDialer(serve func(Transport)) Dialer {
+	return func(ctx context.Context) (Transport, error) {
+		err := ctx.Err()
+		if err != nil {
+			return nil, err
+		}
+
+		client, server := Pipe()
+		go serve(server)
+		return client, nil
+	}
+}
+
+func (end *pipeEnd) Send(ctx context.Context, message []byte) error {
+	// The receiver owns what it gets
+	message = append([]byte(nil), message...)
+
+	select {
+	case <-end.pipe.closed:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case end.out <- message:
+		return nil
+	case <-end.pipe.closed:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (end *pipeEnd) Receive(ctx context.Context) ([]byte, error) {
+	select {
+	case <-end.pipe.closed:
+		return nil, ErrClosed
+	default:
+	}
+
+	select {
+	case message := <-end.in:
+		return message, nil
+	case <-end.pipe.closed:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (end *pipeEnd) Close() error {
+	end.pipe.closeOnce.Do(func() {
+		close(end.pipe.closed)
+	})
+	return nil
+}
@@ -0,0 +1,84 @@
+// Package transport carries the messages between the onepass client and the
+// 1Password helper. A Transport moves whole messages as bytes, whatever is
+// underneath: a websocket, native messaging over the stdio of the helper or,
+// in tests, an in-memory pipe.
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Transport sends and receives whole messages. Send and Receive give up with
+// the error of ctx once it is done.
+type Transport interface {
+	Send(ctx context.Context, message []byte) error
+	Receive(ctx context.Context) ([]byte, error)
+	Close() error
+}
+
+// Dialer connects a new Transport to the helper.
+type Dialer func(ctx context.Context) (Transport, error)
+
+// ErrClosed is returned when a Transport is used after it was closed.
+var ErrClosed = errors.New("use of a closed transport")
+
+// interruptible applies the deadline of ctx to a call on a connection with
+// set, a SetReadDeadline or SetWriteDeadline, and moves the deadline into the
+// past to interrupt the call when ctx is done earlier. The returned function
+// has to be called once the call returned.
+func interruptible(ctx context.Context, set func(time.Time) error) (func(), error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a deadline this clears the one of a previous call
+	deadline, _ := ctx.Deadline()
+	err = set(deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Done() == nil {
+		return func() {}, nil
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = set(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	// The deadline is cleared afterwards, so it doesn't fail what comes
+	// next, like the close frame written by Close
+	return func() {
+		close(stop)
+		<-stopped
+		_ = set(time.Time{})
+	}, nil
+}
+
+// contextError returns the error of ctx instead of err when the call failed
+// because ctx is done or its deadline passed.
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	var netErr net.Error
+	if _, ok := ctx.Deadline(); ok && errors.As(err, &netErr) && netErr.Timeout() {
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,197 @@
+package transport_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	ws "golang.org/x/net/websocket"
+
+	. "github.com/brycekahle/sudolikeaboss/transport"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// blockingClient is a Client whose Receive only returns once it is closed.
+type blockingClient struct {
+	sent   [][]byte
+	closed chan struct{}
+}
+
+func (client *blockingClient) Connect() error {
+	return nil
+}
+
+func (client *blockingClient) Send(v interface{}) error {
+	client.sent = append(client.sent, v.([]byte))
+	return nil
+}
+
+func (client *blockingClient) Receive(v interface{}) error {
+	<-client.closed
+	return errors.New("closed")
+}
+
+func (client *blockingClient) Close() error {
+	close(client.closed)
+	return nil
+}
+
+// fakeGorillaConn answers every message with itself.
+type fakeGorillaConn struct {
+	messages chan []byte
+	types    []int
+}
+
+func (conn *fakeGorillaConn) ReadMessage() (int, []byte, error) {
+	return BinaryMessage, <-conn.messages, nil
+}
+
+func (conn *fakeGorillaConn) WriteMessage(messageType int, data []byte) error {
+	conn.types = append(conn.types, messageType)
+	conn.messages <- data
+	return nil
+}
+
+func (conn *fakeGorillaConn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn *fakeGorillaConn) SetWriteDeadline(t time.Time) error { return nil }
+func (conn *fakeGorillaConn) Close() error                       { return nil }
+
+var _ = Describe("Transport", func() {
+	Describe("Pipe", func() {
+		It("should carry messages both ways", func() {
+			a, b := Pipe()
+			defer a.Close()
+
+			go func() {
+				defer GinkgoRecover()
+
+				message, err := b.Receive(context.Background())
+				Expect(err).To(BeNil())
+				Expect(b.Send(context.Background(), append(message, '!'))).To(Succeed())
+			}()
+
+			Expect(a.Send(context.Background(), []byte("hello"))).To(Succeed())
+			Expect(a.Receive(context.Background())).To(Equal([]byte("hello!")))
+		})
+
+		It("should fail on both ends once closed", func() {
+			a, b := Pipe()
+			Expect(b.Close()).To(Succeed())
+
+			Expect(a.Send(context.Background(), []byte("hello"))).To(MatchError(ErrClosed))
+			_, err := a.Receive(context.Background())
+			Expect(err).To(MatchError(ErrClosed))
+		})
+
+		It("should give up when the context is done", func() {
+			a, _ := Pipe()
+			defer a.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			_, err := a.Receive(ctx)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+	})
+
+	Describe("FromClient", func() {
+		It("should close the client when a call is abandoned", func() {
+			client := &blockingClient{closed: make(chan struct{})}
+
+			transport, err := FromClient(client)(context.Background())
+			Expect(err).To(BeNil())
+			Expect(transport.Send(context.Background(), []byte("hello"))).To(Succeed())
+			Expect(client.sent).To(Equal([][]byte{[]byte("hello")}))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			_, err = transport.Receive(ctx)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Eventually(client.closed).Should(BeClosed())
+
+			Expect(transport.Send(context.Background(), []byte("hello"))).To(MatchError(ErrClosed))
+		})
+	})
+
+	Describe("Gorilla", func() {
+		It("should send messages of the given type", func() {
+			conn := &fakeGorillaConn{messages: make(chan []byte, 1)}
+			transport := NewGorilla(conn, TextMessage)
+
+			Expect(transport.Send(context.Background(), []byte("hello"))).To(Succeed())
+			Expect(transport.Receive(context.Background())).To(Equal([]byte("hello")))
+			Expect(conn.types).To(Equal([]int{TextMessage}))
+		})
+	})
+
+	Describe("Websocket", func() {
+		var (
+			listener net.Listener
+			server   *http.Server
+			uri      string
+		)
+
+		BeforeEach(func() {
+			var err error
+			listener, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+
+			// Echoes the first message, then stays silent
+			server = &http.Server{Handler: ws.Server{
+				Handshake: func(*ws.Config, *http.Request) error { return nil },
+				Handler: func(conn *ws.Conn) {
+					var message []byte
+					if ws.Message.Receive(conn, &message) == nil {
+						_ = ws.Message.Send(conn, message)
+					}
+					_ = ws.Message.Receive(conn, &message)
+				},
+			}}
+			go func() {
+				_ = server.Serve(listener)
+			}()
+
+			uri = "ws://" + listener.Addr().String() + "/"
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should carry messages and honour deadlines", func() {
+			conn, err := ws.Dial(uri, "", "http://localhost/")
+			Expect(err).To(BeNil())
+
+			transport := NewWebsocket(conn, ws.Message)
+			defer transport.Close()
+
+			Expect(transport.Send(context.Background(), []byte("hello"))).To(Succeed())
+			Expect(transport.Receive(context.Background())).To(Equal([]byte("hello")))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			_, err = transport.Receive(ctx)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+
+		It("should interrupt a receive when the context is cancelled", func() {
+			conn, err := ws.Dial(uri, "", "http://localhost/")
+			Expect(err).To(BeNil())
+
+			transport := NewWebsocket(conn, ws.Message)
+			defer transport.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(10*time.Millisecond, cancel)
+
+			_, err = transport.Receive(ctx)
+			Expect(err).To(MatchError(context.Canceled))
+		})
+	})
+})
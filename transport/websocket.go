@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"context"
+
+	ws "golang.org/x/net/websocket"
+)
+
+// WebsocketCodec reads and writes the messages of a golang.org/x/net
+// websocket, ws.Message in most cases.
+type WebsocketCodec interface {
+	Receive(*ws.Conn, interface{}) error
+	Send(*ws.Conn, interface{}) error
+}
+
+type websocketTransport struct {
+	conn  *ws.Conn
+	codec WebsocketCodec
+}
+
+// NewWebsocket adapts a golang.org/x/net websocket connection. Messages are
+// sent as binary frames when codec is ws.Message.
+func NewWebsocket(conn *ws.Conn, codec WebsocketCodec) Transport {
+	return &websocketTransport{conn: conn, codec: codec}
+}
+
+func (transport *websocketTransport) Send(ctx context.Context, message []byte) error {
+	done, err := interruptible(ctx, transport.conn.SetWriteDeadline)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	return contextError(ctx, transport.codec.Send(transport.conn, message))
+}
+
+func (transport *websocketTransport) Receive(ctx context.Context) ([]byte, error) {
+	done, err := interruptible(ctx, transport.conn.SetReadDeadline)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	var message []byte
+	err = transport.codec.Receive(transport.conn, &message)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return message, nil
+}
+
+func (transport *websocketTransport) Close() error {
+	return transport.conn.Close()
+}
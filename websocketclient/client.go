@@ -1,9 +1,19 @@
 package websocketclient
 
 import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
 	ws "golang.org/x/net/websocket"
+
+	"github.com/brycekahle/sudolikeaboss/transport"
 )
 
+// ErrClosed is returned when the client is used after Close.
+var ErrClosed = errors.New("the websocket client is closed")
+
 type Codec interface {
 	Receive(*ws.Conn, interface{}) error
 	Send(*ws.Conn, interface{}) error
@@ -14,18 +24,36 @@ type Client struct {
 	WebsocketURI      string
 	WebsocketProtocol string
 	WebsocketOrigin   string
-	conn              *ws.Conn
-	dial              func(string, string, string) (*ws.Conn, error)
+	dial              func(*ws.Config) (*ws.Conn, error)
 	codec             Codec
+
+	// mu guards conn and closed, since Close may be called while another
+	// goroutine sends or receives
+	mu     sync.Mutex
+	conn   *ws.Conn
+	closed bool
 }
 
 func NewClient(websocketURI string, websocketProtocol string, websocketOrigin string) *Client {
-	return NewCustomClient(websocketURI, websocketProtocol, websocketOrigin, ws.Dial, ws.Message)
+	return NewClientWithConfig(websocketURI, websocketProtocol, websocketOrigin, ws.DialConfig, ws.Message)
 }
 
+// NewCustomClient connects with dial, which isn't bounded by the deadline of
+// the context given to Dial. Use NewClientWithConfig for that.
 func NewCustomClient(websocketURI string, websocketProtocol string, websocketOrigin string,
 	dial func(string, string, string) (*ws.Conn, error), codec Codec) *Client {
 
+	dialConfig := func(*ws.Config) (*ws.Conn, error) {
+		return dial(websocketURI, websocketProtocol, websocketOrigin)
+	}
+	return NewClientWithConfig(websocketURI, websocketProtocol, websocketOrigin, dialConfig, codec)
+}
+
+// NewClientWithConfig connects with dial, passing it a configuration whose
+// dialer has the deadline of the context given to Dial.
+func NewClientWithConfig(websocketURI string, websocketProtocol string, websocketOrigin string,
+	dial func(*ws.Config) (*ws.Conn, error), codec Codec) *Client {
+
 	client := Client{
 		WebsocketURI:      websocketURI,
 		WebsocketProtocol: websocketProtocol,
@@ -38,20 +66,105 @@ func NewCustomClient(websocketURI string, websocketProtocol string, websocketOri
 }
 
 func (client *Client) Connect() error {
-	conn, err := client.dial(client.WebsocketURI, client.WebsocketProtocol, client.WebsocketOrigin)
+	return client.connect(context.Background())
+}
+
+// connect dials with the deadline of ctx, so a helper that doesn't accept the
+// connection can't block forever.
+func (client *Client) connect(ctx context.Context) error {
+	if client.isClosed() {
+		return ErrClosed
+	}
+
+	config, err := ws.NewConfig(client.WebsocketURI, client.WebsocketOrigin)
+	if err != nil {
+		return err
+	}
+	if client.WebsocketProtocol != "" {
+		config.Protocol = []string{client.WebsocketProtocol}
+	}
+	deadline, _ := ctx.Deadline()
+	config.Dialer = &net.Dialer{Deadline: deadline}
+
+	conn, err := client.dial(config)
 	if err != nil {
 		return err
 	}
 
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.closed {
+		_ = conn.Close()
+		return ErrClosed
+	}
 	client.conn = conn
 
 	return nil
 }
 
+func (client *Client) isClosed() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.closed
+}
+
+// connection returns the current connection, or ErrClosed after Close.
+func (client *Client) connection() (*ws.Conn, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.closed {
+		return nil, ErrClosed
+	}
+	return client.conn, nil
+}
+
+// Dial connects and returns the connection as a transport.Transport, which
+// honours the deadline of the contexts it is given.
+func (client *Client) Dial(ctx context.Context) (transport.Transport, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.connection()
+	if err != nil {
+		return nil, err
+	}
+	return transport.NewWebsocket(conn, client.codec), nil
+}
+
 func (client *Client) Receive(v interface{}) error {
-	return client.codec.Receive(client.conn, v)
+	conn, err := client.connection()
+	if err != nil {
+		return err
+	}
+	return client.codec.Receive(conn, v)
 }
 
 func (client *Client) Send(v interface{}) error {
-	return client.codec.Send(client.conn, v)
+	conn, err := client.connection()
+	if err != nil {
+		return err
+	}
+	return client.codec.Send(conn, v)
+}
+
+// Close closes the connection. The client can't be connected again.
+func (client *Client) Close() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.closed {
+		return nil
+	}
+	client.closed = true
+
+	if client.conn == nil {
+		return nil
+	}
+	return client.conn.Close()
 }